@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"lxcpanel/common"
+	"lxcpanel/metrics"
+	"net/netip"
+
+	"github.com/charmbracelet/log"
+	"github.com/charmbracelet/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+var errDenied = errors.New("permission denied")
+
+// mfaVerifiedKey marks connections that passed the TOTP step.
+var mfaVerifiedKey = &struct{ name string }{"mfa-verified"}
+
+// registeredKey holds the username of an account created by redeeming an
+// invite during authentication.
+var registeredKey = &struct{ name string }{"registered"}
+
+// Extensions of the permissions of a connection, describing the credential
+// that authenticated it.
+const (
+	// fingerprintExtension holds the fingerprint of the registered key.
+	fingerprintExtension = "lxcpanel-fingerprint"
+	// provisionExtension marks certificates of users to be created.
+	provisionExtension = "lxcpanel-provision"
+)
+
+// inviteAttempts is the number of invite codes that can be tried per
+// connection.
+const inviteAttempts = 3
+
+// Authenticator authenticates SSH logins with registered public keys or
+// certificates from trusted CAs, followed by a keyboard-interactive TOTP
+// step for users with MFA enabled. Unknown users presenting an unregistered
+// key are offered to redeem an invite code instead.
+type Authenticator struct {
+	// CAAutoProvision creates unknown users presenting a valid certificate.
+	CAAutoProvision bool
+	// RequireAdminMFA withholds the permissions of elevated roles from
+	// sessions that didn't pass MFA.
+	RequireAdminMFA bool
+	// IPBans and UserBans temporarily ban addresses and usernames after
+	// repeated failed logins, MFABans the TOTP step of usernames after
+	// repeated wrong codes.
+	IPBans   common.BanPolicy
+	UserBans common.BanPolicy
+	MFABans  common.BanPolicy
+	// Connections from Deny are dropped, as are those not in Allow unless
+	// it is empty.
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// Option installs the authenticator on an SSH server. It replaces the
+// server's own auth handlers because those can't request a second factor.
+func (a *Authenticator) Option() ssh.Option {
+	return func(srv *ssh.Server) error {
+		srv.PublicKeyHandler = nil
+		srv.PasswordHandler = nil
+		srv.KeyboardInteractiveHandler = nil
+		srv.ConnCallback = a.accept
+		srv.ServerConfigCallback = func(ctx ssh.Context) *gossh.ServerConfig {
+			return &gossh.ServerConfig{
+				// The server enables NoClientAuth as no handler is set,
+				// reject the "none" method explicitly.
+				NoClientAuthCallback: func(gossh.ConnMetadata) (*gossh.Permissions, error) {
+					return nil, errDenied
+				},
+				PublicKeyCallback: func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+					return a.publicKey(ctx, conn, key)
+				},
+				AuthLogCallback: func(conn gossh.ConnMetadata, method string, err error) {
+					// Successful queries for keys don't get here, a nil
+					// error means the authentication completed
+					if err == nil {
+						a.succeed(ctx, conn)
+					}
+				},
+			}
+		}
+		return nil
+	}
+}
+
+// Role returns the role granted to the session of user. With
+// RequireAdminMFA, elevated roles fall back to the user role unless the
+// session passed MFA.
+func (a *Authenticator) Role(ctx ssh.Context, user common.DBUser) common.Role {
+	role, err := common.LookupRole(user.Role)
+	if err != nil {
+		log.Error("Invalid role", "user", user.Username, "error", err)
+		role, _ = common.LookupRole(common.RoleUser)
+	}
+	if role.Elevated() && a.RequireAdminMFA && !MFAVerified(ctx) {
+		role, _ = common.LookupRole(common.RoleUser)
+	}
+	return role
+}
+
+// Registered returns the username of the account created by redeeming an
+// invite on this connection, if any. It may differ from the login name.
+func Registered(ctx ssh.Context) (string, bool) {
+	username, ok := ctx.Value(registeredKey).(string)
+	return username, ok
+}
+
+// MFAVerified reports whether the connection passed the TOTP step.
+func MFAVerified(ctx ssh.Context) bool {
+	verified, _ := ctx.Value(mfaVerifiedKey).(bool)
+	return verified
+}
+
+func (a *Authenticator) publicKey(ctx ssh.Context, conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+	username := conn.User()
+	// A banned username can still log in with a valid key, so others can't
+	// lock the user out by failing logins under its name
+	perms, err := a.checkKey(username, conn, key)
+	if err != nil {
+		if a.banned(common.BanUser, username) {
+			return nil, errDenied
+		}
+		ctx.SetValue(attemptedUserKey, username)
+		if a.canRedeemInvite(username, key) {
+			return nil, &gossh.PartialSuccessError{
+				Next: gossh.ServerAuthCallbacks{
+					KeyboardInteractiveCallback: func(conn gossh.ConnMetadata, challenge gossh.KeyboardInteractiveChallenge) (*gossh.Permissions, error) {
+						return a.redeemInvite(ctx, conn, key, challenge)
+					},
+				},
+			}
+		}
+		metrics.AuthAttemptsTotal.WithLabelValues("failure").Inc()
+		return nil, errDenied
+	}
+	ctx.SetValue(attemptedUserKey, username)
+	ctx.SetValue(ssh.ContextKeyPublicKey, key)
+	mfa, err := common.MFAEnabled(username)
+	if err != nil {
+		log.Error("Error looking up MFA", "user", username, "error", err)
+		metrics.AuthAttemptsTotal.WithLabelValues("failure").Inc()
+		return nil, errDenied
+	}
+	if mfa {
+		return nil, &gossh.PartialSuccessError{
+			Next: gossh.ServerAuthCallbacks{
+				KeyboardInteractiveCallback: func(conn gossh.ConnMetadata, challenge gossh.KeyboardInteractiveChallenge) (*gossh.Permissions, error) {
+					return a.totp(ctx, conn, challenge, perms)
+				},
+			},
+		}
+	}
+	return perms, nil
+}
+
+// RejectSuspended ends the session of a suspended user, telling them why.
+// Suspended users can still authenticate so that the reason is only shown
+// to the holder of a valid key, others get the same denial as for any
+// unknown key.
+func RejectSuspended(sess ssh.Session, user common.DBUser) bool {
+	if !user.Suspended() {
+		return false
+	}
+	log.Warn("Login rejected", "user", user.Username, "reason", "suspended")
+	metrics.AuthRejectedTotal.WithLabelValues("suspended").Inc()
+	message := fmt.Sprintf("Account %s is suspended", user.Username)
+	if user.SuspendReason != "" {
+		message += ": " + user.SuspendReason
+	}
+	fmt.Fprintln(sess, message+". Please contact an administrator.")
+	return true
+}
+
+// succeed marks the connection authenticated once its user completed all
+// authentication steps, clearing the failed logins of the user and of the
+// address, so that a few typos behind a shared address don't add up to a
+// ban.
+func (a *Authenticator) succeed(ctx ssh.Context, conn gossh.ConnMetadata) {
+	ctx.SetValue(authenticatedKey, true)
+	username := conn.User()
+	if err := common.ResetLoginFailures(common.BanUser, username); err != nil {
+		log.Error("Error resetting login failures", "user", username, "error", err)
+	}
+	if addr := remoteIP(conn.RemoteAddr()); addr.IsValid() {
+		if err := common.ResetLoginFailures(common.BanIP, addr.String()); err != nil {
+			log.Error("Error resetting login failures", "ip", addr, "error", err)
+		}
+	}
+	metrics.AuthAttemptsTotal.WithLabelValues("success").Inc()
+}
+
+// FinishLogin completes the login of the user of a session once its
+// connection authenticated, recording the use of its key or creating the
+// user of an auto-provisioned certificate.
+func (a *Authenticator) FinishLogin(ctx ssh.Context) error {
+	conn, ok := ctx.Value(ssh.ContextKeyConn).(*gossh.ServerConn)
+	if !ok || conn.Permissions == nil {
+		return nil
+	}
+	return finishLogin(conn.User(), conn.Permissions)
+}
+
+func finishLogin(username string, perms *gossh.Permissions) error {
+	if keyID, ok := perms.Extensions[provisionExtension]; ok {
+		if err := common.ProvisionUser(username); err != nil {
+			return err
+		}
+		log.Info("User provisioned from certificate", "user", username, "key_id", keyID)
+	}
+	if fingerprint, ok := perms.Extensions[fingerprintExtension]; ok {
+		return common.UsePubkey(username, fingerprint)
+	}
+	return nil
+}
+
+// checkKey checks whether username may log in with key, returning the
+// permissions of the login. The key is only checked here, the signature made
+// with it is verified afterwards, so anything done for the login is left to
+// FinishLogin through the permissions of the key that completed
+// authentication.
+func (a *Authenticator) checkKey(username string, conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+	perms := &gossh.Permissions{Extensions: map[string]string{}}
+	if cert, ok := key.(*gossh.Certificate); ok {
+		err := common.AuthenticateCertificate(username, cert, conn.RemoteAddr())
+		if errors.Is(err, common.ErrUnknownUser) && a.CAAutoProvision {
+			perms.Extensions[provisionExtension] = cert.KeyId
+			return perms, nil
+		}
+		if err != nil {
+			log.Warn("Certificate rejected", "user", username, "key_id", cert.KeyId, "error", err)
+			return nil, err
+		}
+		return perms, nil
+	}
+	fingerprint := gossh.FingerprintSHA256(key)
+	ok, err := common.PubkeyValid(username, fingerprint)
+	if err != nil {
+		log.Error("Error looking up public key", "error", err)
+		return nil, err
+	}
+	if !ok {
+		return nil, errDenied
+	}
+	perms.Extensions[fingerprintExtension] = fingerprint
+	return perms, nil
+}
+
+// totp asks for the verification code of the user. Valid keys bypass
+// username bans, so wrong codes are counted per user on their own, keeping
+// holders of a stolen key from guessing codes from many addresses.
+func (a *Authenticator) totp(ctx ssh.Context, conn gossh.ConnMetadata, challenge gossh.KeyboardInteractiveChallenge, perms *gossh.Permissions) (*gossh.Permissions, error) {
+	username := conn.User()
+	if a.banned(common.BanMFA, username) {
+		return nil, errDenied
+	}
+	answers, err := challenge("", "Two-factor authentication", []string{"Verification code: "}, []bool{false})
+	if err != nil {
+		return nil, err
+	}
+	if len(answers) != 1 {
+		return nil, errDenied
+	}
+	if err = common.VerifyMFA(username, answers[0]); err != nil {
+		log.Warn("MFA failed", "user", username, "error", err)
+		metrics.AuthAttemptsTotal.WithLabelValues("failure").Inc()
+		recordFailure(common.BanMFA, username, a.MFABans)
+		return nil, errDenied
+	}
+	if err = common.ResetLoginFailures(common.BanMFA, username); err != nil {
+		log.Error("Error resetting MFA failures", "user", username, "error", err)
+	}
+	ctx.SetValue(mfaVerifiedKey, true)
+	return perms, nil
+}
+
+// canRedeemInvite reports whether the login of username with key may
+// continue with an invite code: the user must not exist, the key must not be
+// a certificate and some invite must still be open.
+func (a *Authenticator) canRedeemInvite(username string, key gossh.PublicKey) bool {
+	if _, ok := key.(*gossh.Certificate); ok {
+		return false
+	}
+	if _, err := common.GetUser(username); !errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	open, err := common.HasOpenInvites()
+	if err != nil {
+		log.Error("Error looking up invites", "error", err)
+		return false
+	}
+	return open
+}
+
+// redeemInvite asks for an invite code and a username, defaulting to the
+// login name, and creates the account with key registered to it.
+func (a *Authenticator) redeemInvite(ctx ssh.Context, conn gossh.ConnMetadata, key gossh.PublicKey, challenge gossh.KeyboardInteractiveChallenge) (*gossh.Permissions, error) {
+	instruction := fmt.Sprintf("No account uses this key. Enter an invite code to register it, leave the username empty to use %q.", conn.User())
+	for range inviteAttempts {
+		answers, err := challenge("", instruction, []string{"Invite code: ", "Username: "}, []bool{true, true})
+		if err != nil {
+			return nil, err
+		}
+		if len(answers) != 2 {
+			return nil, errDenied
+		}
+		username := answers[1]
+		if username == "" {
+			username = conn.User()
+		}
+		err = common.RedeemInvite(answers[0], username, string(gossh.MarshalAuthorizedKey(key)))
+		if err == nil {
+			log.Info("Invite redeemed", "user", username, "fingerprint", gossh.FingerprintSHA256(key))
+			ctx.SetValue(ssh.ContextKeyPublicKey, key)
+			ctx.SetValue(registeredKey, username)
+			return ctx.Permissions().Permissions, nil
+		}
+		log.Warn("Invite rejected", "user", username, "error", err)
+		metrics.AuthAttemptsTotal.WithLabelValues("failure").Inc()
+		instruction = "Registration failed, try again."
+		for _, known := range []error{common.ErrInvalidInvite, common.ErrInvalidUsername, common.ErrUsernameTaken, common.ErrInvalidPubkey, common.ErrDuplicatePubkey} {
+			if errors.Is(err, known) {
+				instruction = "Error: " + err.Error()
+			}
+		}
+	}
+	return nil, errDenied
+}
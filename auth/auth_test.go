@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"lxcpanel/common"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type testConn struct {
+	user string
+}
+
+func (c testConn) User() string          { return c.user }
+func (c testConn) SessionID() []byte     { return nil }
+func (c testConn) ClientVersion() []byte { return nil }
+func (c testConn) ServerVersion() []byte { return nil }
+func (c testConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 50000}
+}
+func (c testConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 2222}
+}
+
+func newSigner(t *testing.T) gossh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestCertificateAutoProvision(t *testing.T) {
+	common.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(func() { common.DB.Close() })
+	ca := newSigner(t)
+	if err := common.AddCertAuthority("corp", string(gossh.MarshalAuthorizedKey(ca.PublicKey()))); err != nil {
+		t.Fatal(err)
+	}
+	cert := &gossh.Certificate{
+		Key:             newSigner(t).PublicKey(),
+		CertType:        gossh.UserCert,
+		KeyId:           "carol@example.com",
+		ValidPrincipals: []string{"carol"},
+		ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+
+	off := &Authenticator{}
+	if _, err := off.checkKey("carol", testConn{"carol"}, cert); err == nil {
+		t.Fatal("certificate of an unknown user accepted without auto-provisioning")
+	}
+
+	on := &Authenticator{CAAutoProvision: true}
+	perms, err := on.checkKey("carol", testConn{"carol"}, cert)
+	if err != nil {
+		t.Fatalf("checkKey() = %v, want nil", err)
+	}
+	// The signature isn't verified yet, so the user must not exist before
+	// the login finishes
+	if _, err = common.GetUser("carol"); err == nil {
+		t.Fatal("user created while checking the certificate")
+	}
+	if err = finishLogin("carol", perms); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = common.GetUser("carol"); err != nil {
+		t.Fatalf("user not provisioned: %v", err)
+	}
+}
+
+// testContext is the context of a connection holding its values.
+type testContext struct {
+	ssh.Context
+	values map[any]any
+}
+
+func (c *testContext) SetValue(key, value any) { c.values[key] = value }
+func (c *testContext) Value(key any) any       { return c.values[key] }
+
+func TestTOTPBan(t *testing.T) {
+	common.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(func() { common.DB.Close() })
+	for _, username := range []string{"alice", "bob"} {
+		if err := common.AddUser(username, common.RoleUser, 3); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := common.EnrollMFA(username); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := common.DB.Exec("UPDATE mfa SET enabled = TRUE"); err != nil {
+		t.Fatal(err)
+	}
+	a := &Authenticator{MFABans: common.BanPolicy{Threshold: 3, Ban: time.Hour, MaxBan: time.Hour, Window: time.Hour}}
+	// totp asks for a code, answering a wrong one, and reports whether it
+	// was asked
+	totp := func(username string) bool {
+		t.Helper()
+		asked := false
+		challenge := func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			asked = true
+			return []string{"wrong"}, nil
+		}
+		ctx := &testContext{values: map[any]any{}}
+		if _, err := a.totp(ctx, testConn{username}, challenge, &gossh.Permissions{}); err == nil {
+			t.Fatal("wrong code accepted")
+		}
+		if MFAVerified(ctx) {
+			t.Fatal("connection marked as verified")
+		}
+		return asked
+	}
+
+	// Every connection, e.g. from another address, counts against the user
+	for i := range 3 {
+		if !totp("alice") {
+			t.Fatalf("code %d not asked", i+1)
+		}
+	}
+	if totp("alice") {
+		t.Error("code asked while banned")
+	}
+	if !totp("bob") {
+		t.Error("ban of alice applies to bob")
+	}
+	if err := common.Unban(common.BanMFA, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if !totp("alice") {
+		t.Error("code not asked after unban")
+	}
+}
+
+func TestSucceedClearsFailures(t *testing.T) {
+	common.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(func() { common.DB.Close() })
+	a := &Authenticator{}
+	conn := testConn{"alice"}
+	for _, failure := range []struct{ kind, value string }{
+		{common.BanIP, "192.0.2.10"},
+		{common.BanUser, "alice"},
+		{common.BanIP, "198.51.100.7"},
+	} {
+		if _, err := common.RecordLoginFailure(failure.kind, failure.value, a.IPBans); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := &testContext{values: map[any]any{}}
+	a.succeed(ctx, conn)
+
+	failures, err := common.ListLoginFailures(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the failures of other addresses are kept
+	if len(failures) != 1 || failures[0].Value != "198.51.100.7" {
+		t.Fatalf("failures after login: %+v", failures)
+	}
+	if authenticated, _ := ctx.Value(authenticatedKey).(bool); !authenticated {
+		t.Error("connection not marked as authenticated")
+	}
+}
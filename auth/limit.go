@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"fmt"
+	"lxcpanel/common"
+	"lxcpanel/metrics"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/charmbracelet/ssh"
+)
+
+// attemptedUserKey holds the login name of a connection once a credential
+// was checked, so the connection counts as a failure if it closes without
+// authenticating.
+var attemptedUserKey = &struct{ name string }{"attempted-user"}
+
+// authenticatedKey marks connections that completed authentication.
+var authenticatedKey = &struct{ name string }{"authenticated"}
+
+// ParsePrefixes parses a comma-separated list of CIDR ranges and addresses.
+func ParsePrefixes(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address or CIDR range %q", item)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func remoteIP(remote net.Addr) netip.Addr {
+	if tcp, ok := remote.(*net.TCPAddr); ok {
+		return tcp.AddrPort().Addr().Unmap()
+	}
+	return netip.Addr{}
+}
+
+// addressAllowed checks addr against the deny list, then the allow list if
+// there is one.
+func (a *Authenticator) addressAllowed(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return len(a.Allow) == 0
+	}
+	for _, prefix := range a.Deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(a.Allow) == 0 {
+		return true
+	}
+	for _, prefix := range a.Allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// accept drops connections from addresses that are not allowed or banned and
+// tracks the others to count failed logins.
+func (a *Authenticator) accept(ctx ssh.Context, conn net.Conn) net.Conn {
+	addr := remoteIP(conn.RemoteAddr())
+	if !a.addressAllowed(addr) {
+		log.Warn("Connection rejected", "ip", addr, "reason", "address not allowed")
+		metrics.AuthRejectedTotal.WithLabelValues("address").Inc()
+		return nil
+	}
+	ip := addr.String()
+	bannedUntil, err := common.LoginBannedUntil(common.BanIP, ip)
+	if err != nil {
+		log.Error("Error looking up ban", "ip", ip, "error", err)
+	} else if bannedUntil != nil {
+		log.Warn("Connection rejected", "ip", ip, "reason", "banned", "until", bannedUntil.Local())
+		metrics.AuthRejectedTotal.WithLabelValues("banned_ip").Inc()
+		return nil
+	}
+	return &trackedConn{Conn: conn, a: a, ctx: ctx, ip: ip}
+}
+
+// banned reports whether logins as username are banned, or for BanMFA
+// their TOTP step.
+func (a *Authenticator) banned(kind string, username string) bool {
+	bannedUntil, err := common.LoginBannedUntil(kind, username)
+	if err != nil {
+		log.Error("Error looking up ban", "user", username, "error", err)
+		return false
+	}
+	if bannedUntil == nil {
+		return false
+	}
+	log.Warn("Login rejected", "user", username, "reason", "banned", "kind", kind, "until", bannedUntil.Local())
+	metrics.AuthRejectedTotal.WithLabelValues("banned_" + kind).Inc()
+	return true
+}
+
+// loginFailed counts a failed login of username from ip, banning either when
+// their policy says so.
+func (a *Authenticator) loginFailed(ip string, username string) {
+	log.Warn("Login failed", "ip", ip, "user", username)
+	recordFailure(common.BanIP, ip, a.IPBans)
+	recordFailure(common.BanUser, username, a.UserBans)
+}
+
+// recordFailure counts a failure of value, banning it when policy says so.
+func recordFailure(kind string, value string, policy common.BanPolicy) {
+	bannedUntil, err := common.RecordLoginFailure(kind, value, policy)
+	if err != nil {
+		log.Error("Error recording login failure", kind, value, "error", err)
+		return
+	}
+	if bannedUntil != nil {
+		log.Warn("Login banned", kind, value, "until", bannedUntil.Local())
+		metrics.AuthBansTotal.WithLabelValues(kind).Inc()
+	}
+}
+
+// trackedConn counts a failed login when a connection that tried to
+// authenticate closes without succeeding. Counting connections rather than
+// keys keeps clients offering several keys from being banned.
+type trackedConn struct {
+	net.Conn
+	a    *Authenticator
+	ctx  ssh.Context
+	ip   string
+	once sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		username, attempted := c.ctx.Value(attemptedUserKey).(string)
+		authenticated, _ := c.ctx.Value(authenticatedKey).(bool)
+		if attempted && !authenticated {
+			c.a.loginFailed(c.ip, username)
+		}
+	})
+	return c.Conn.Close()
+}
@@ -1,10 +1,18 @@
 package cmd
 
 import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"lxcpanel/common"
+	"lxcpanel/lxc"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
@@ -15,6 +23,10 @@ type adminCmd struct {
 	ctx *CommandContext
 }
 
+func (command *adminCmd) Meta() CommandMeta {
+	return cobraMeta(&command.cmd)
+}
+
 func (command *adminCmd) Exec(ctx *CommandContext, args []string) error {
 	command.cmd.SetArgs(args[1:])
 	command.cmd.SetIn(ctx)
@@ -24,60 +36,120 @@ func (command *adminCmd) Exec(ctx *CommandContext, args []string) error {
 	return command.cmd.Execute()
 }
 
-func NewAdminCmd() Command {
+func (command *adminCmd) Complete(ctx *CommandContext, args []string, toComplete string) []string {
+	command.ctx = ctx
+	return completeCobra(&command.cmd, args, toComplete)
+}
+
+// NewAdminCmd returns the admin command with the subcommands role may run.
+func NewAdminCmd(role common.Role) Command {
 	command := &adminCmd{
 		cmd: cobra.Command{
-			Use: "admin",
+			Use:   "admin",
+			Short: "Administer users, roles, teams, keys, instances, certificate authorities, invites, login bans and webhooks",
 		},
 		ctx: nil,
 	}
 	userCmd := &cobra.Command{
-		Use: "user",
+		Use:   "user",
+		Short: "Manage users",
 	}
 	command.cmd.AddCommand(userCmd)
-	userCmd.AddCommand(&cobra.Command{
-		Use: "list",
+	userListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List users",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			users, err := common.ListUsers()
 			if err != nil {
 				return err
 			}
-			table := tablewriter.NewWriter(cmd.OutOrStdout())
-			table.SetRowLine(true)
-			table.SetAlignment(tablewriter.ALIGN_LEFT)
-			table.SetHeader([]string{"Username", "Admin", "Max Instance Count"})
-			for _, user := range users {
-				table.Append([]string{user.Username, fmt.Sprintf("%t", user.Admin), strconv.Itoa(user.MaxInstanceCount)})
-			}
-			table.Render()
-			return nil
+			columns := append(slices.Clone(userColumns),
+				timeColumn("suspended_at", "Suspended At", "", func(u common.DBUser) *time.Time { return u.SuspendedAt }),
+				Column[common.DBUser]{Name: "suspend_reason", Header: "Suspend Reason", Value: func(u common.DBUser) string { return u.SuspendReason }},
+			)
+			return PrintList(cmd, users, columns)
 		},
-	})
+	}
+	AddFormatFlags(userListCmd)
+	userCmd.AddCommand(userListCmd)
 	userAddCmd := &cobra.Command{
-		Use:  "add <username>",
-		Args: ExactArgs(1),
+		Use:   "add <username>",
+		Short: "Add a user",
+		Args:  ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			maxInstanceCount, err := cmd.Flags().GetInt("max-instance-count")
 			if err != nil {
 				return err
 			}
-			admin := cmd.Flags().Changed("admin")
-			return common.AddUser(args[0], admin, maxInstanceCount)
+			role, err := cmd.Flags().GetString("role")
+			if err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("admin") {
+				role = common.RoleAdmin
+			}
+			return common.AddUser(args[0], role, maxInstanceCount)
 		},
 	}
-	userAddCmd.Flags().Bool("admin", false, "Make the user an admin")
+	userAddCmd.Flags().String("role", common.RoleUser, "Role of the user, see \"admin role list\"")
+	userAddCmd.Flags().Bool("admin", false, "Make the user an admin, same as --role admin")
 	userAddCmd.Flags().IntP("max-instance-count", "n", 3, "The maximum number of instances the user can create")
 	userCmd.AddCommand(userAddCmd)
 	userCmd.AddCommand(&cobra.Command{
-		Use:  "delete <username>",
-		Args: ExactArgs(1),
+		Use:   "delete <username>",
+		Short: "Delete a user",
+		Args:  ExactArgs(1),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			return completeUsernames()
+		}),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := common.DeleteUser(command.ctx.Context(), args[0])
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("user %s not found", args[0])
+			}
+			return err
+		},
+	})
+	addAdminLifecycleCmds(command, userCmd)
+	userCmd.AddCommand(&cobra.Command{
+		Use:   "role <username> <role>",
+		Short: "Assign a role to a user",
+		Args:  ExactArgs(2),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			switch len(args) {
+			case 0:
+				return completeUsernames(), cobra.ShellCompDirectiveNoFileComp
+			case 1:
+				return completeRoles(), cobra.ShellCompDirectiveNoFileComp
+			}
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := common.SetRole(args[0], args[1])
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("user %s not found", args[0])
+			}
+			return err
+		},
+	})
+	userCmd.AddCommand(&cobra.Command{
+		Use:   "reset-mfa <username>",
+		Short: "Remove a user's two-factor authentication, e.g. after losing their device",
+		Args:  ExactArgs(1),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			return completeUsernames()
+		}),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return common.DeleteUser(args[0])
+			return common.ResetMFA(args[0])
 		},
 	})
 	userCmd.AddCommand(&cobra.Command{
-		Use:  "instances <username> <num>",
-		Args: ExactArgs(2),
+		Use:   "instances <username> <num>",
+		Short: "Change a user's instance quota",
+		Args:  ExactArgs(2),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			return completeUsernames()
+		}),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			maxInstanceCount, err := strconv.Atoi(args[1])
 			if err != nil {
@@ -87,30 +159,41 @@ func NewAdminCmd() Command {
 		},
 	})
 	pubkeyCmd := &cobra.Command{
-		Use: "pubkey",
+		Use:   "pubkey",
+		Short: "Manage public keys of all users",
 	}
 	command.cmd.AddCommand(pubkeyCmd)
-	pubkeyCmd.AddCommand(&cobra.Command{
-		Use: "list",
+	pubkeyListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List public keys of all users",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			pubkeys, err := common.ListAllPubkeys()
 			if err != nil {
 				return err
 			}
-			table := tablewriter.NewWriter(cmd.OutOrStdout())
-			table.SetRowLine(true)
-			table.SetHeader([]string{"Fingerprint", "Username"})
-			for _, pubkey := range pubkeys {
-				table.Append([]string{pubkey.Fingerprint[:16], pubkey.Username})
+			columns := []Column[common.DBPubKey]{
+				pubkeyFingerprintColumn,
+				{Name: "username", Header: "Username", Value: func(k common.DBPubKey) string { return k.Username }},
+				pubkeyLabelColumn,
+				pubkeyCommentColumn,
 			}
-			table.Render()
-			return nil
+			return PrintList(cmd, pubkeys, append(columns, pubkeyTimeColumns...))
 		},
-	})
+	}
+	AddFormatFlags(pubkeyListCmd)
+	pubkeyCmd.AddCommand(pubkeyListCmd)
 
 	pubkeyCmd.AddCommand(&cobra.Command{
-		Use:  "show <fingerprint>",
-		Args: ExactArgs(1),
+		Use:   "show <fingerprint>",
+		Short: "Show a public key",
+		Args:  ExactArgs(1),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			keys, err := common.ListAllPubkeys()
+			if err != nil {
+				return nil
+			}
+			return completeFingerprints(keys)
+		}),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			pubkey, err := common.GetPubkey(args[0])
 			if err != nil {
@@ -118,6 +201,11 @@ func NewAdminCmd() Command {
 			}
 			fmt.Fprintf(cmd.OutOrStdout(), "Username: %s\n", pubkey.Username)
 			fmt.Fprintf(cmd.OutOrStdout(), "Fingerprint: %s\n", pubkey.Fingerprint)
+			fmt.Fprintf(cmd.OutOrStdout(), "Label: %s\n", pubkey.Label)
+			fmt.Fprintf(cmd.OutOrStdout(), "Comment: %s\n", pubkey.Comment)
+			for _, column := range pubkeyTimeColumns {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: %s\n", column.Header, column.Table(pubkey))
+			}
 			fmt.Fprintln(cmd.OutOrStdout(), "Public key:")
 			fmt.Fprintln(cmd.OutOrStdout(), pubkey.PEM)
 			return nil
@@ -125,22 +213,226 @@ func NewAdminCmd() Command {
 	})
 
 	pubkeyCmd.AddCommand(&cobra.Command{
-		Use:  "delete <username> <fingerprint>",
-		Args: ExactArgs(2),
+		Use:   "delete <username> <fingerprint>",
+		Short: "Delete a user's public key",
+		Args:  ExactArgs(2),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			switch len(args) {
+			case 0:
+				return completeUsernames(), cobra.ShellCompDirectiveNoFileComp
+			case 1:
+				keys, err := common.ListPubkeys(args[0])
+				if err != nil {
+					return nil, cobra.ShellCompDirectiveNoFileComp
+				}
+				return completeFingerprints(keys), cobra.ShellCompDirectiveNoFileComp
+			}
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return common.DeletePubkey(args[0], args[1])
 		},
 	})
 
-	pubkeyCmd.AddCommand(&cobra.Command{
-		Use:  "add <username> <pubkey>",
-		Args: MinimumNArgs(2),
+	pubkeyAddCmd := &cobra.Command{
+		Use:   "add <username> <pubkey>",
+		Short: "Add a public key to a user",
+		Args:  MinimumNArgs(2),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			return completeUsernames()
+		}),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			label, expiresAt, err := pubkeyFlags(cmd)
+			if err != nil {
+				return err
+			}
 			key := strings.Join(args[1:], " ")
-			return common.AddPubkey(args[0], key)
+			return common.AddPubkey(args[0], key, label, expiresAt)
+		},
+	}
+	addPubkeyFlags(pubkeyAddCmd)
+	pubkeyCmd.AddCommand(pubkeyAddCmd)
+
+	pubkeyPruneCmd := &cobra.Command{
+		Use:   "prune <days>",
+		Short: "Delete public keys unused for a number of days",
+		Long:  "Delete public keys not used to log in for the given number of days. Keys that were never used count from when they were added.",
+		Args:  ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			days, err := strconv.Atoi(args[0])
+			if err != nil {
+				return err
+			}
+			if days < 1 {
+				return fmt.Errorf("days must be at least 1")
+			}
+			dryRun, err := cmd.Flags().GetBool("dry-run")
+			if err != nil {
+				return err
+			}
+			keys, err := common.PrunePubkeys(time.Now().AddDate(0, 0, -days), dryRun)
+			if err != nil {
+				return err
+			}
+			verb := "Deleted"
+			if dryRun {
+				verb = "Would delete"
+			}
+			for _, key := range keys {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s %s (%s)\n", verb, key.Fingerprint, key.Username)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s %d key(s)\n", verb, len(keys))
+			return nil
+		},
+	}
+	pubkeyPruneCmd.Flags().Bool("dry-run", false, "Only list the keys that would be deleted")
+	pubkeyCmd.AddCommand(pubkeyPruneCmd)
+
+	command.cmd.AddCommand(newAdminRoleCmd())
+	command.cmd.AddCommand(newAdminInstanceCmd(command))
+	command.cmd.AddCommand(newAdminTeamCmd(command))
+	command.cmd.AddCommand(newAdminCACmd())
+	command.cmd.AddCommand(newAdminInviteCmd(command))
+	command.cmd.AddCommand(newAdminBanCmd())
+	command.cmd.AddCommand(newAdminJobCmd())
+
+	webhookCmd := &cobra.Command{
+		Use:   "webhook",
+		Short: "Manage lifecycle webhooks",
+	}
+	command.cmd.AddCommand(webhookCmd)
+	webhookCmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List webhooks",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			webhooks, err := common.ListWebhooks()
+			if err != nil {
+				return err
+			}
+			table := tablewriter.NewWriter(cmd.OutOrStdout())
+			table.SetRowLine(true)
+			table.SetAlignment(tablewriter.ALIGN_LEFT)
+			table.SetHeader([]string{"ID", "URL", "Events", "Created At"})
+			for _, webhook := range webhooks {
+				events := strings.Join(webhook.Events, ", ")
+				if events == "" {
+					events = "all"
+				}
+				table.Append([]string{strconv.FormatInt(webhook.ID, 10), webhook.URL, events, webhook.CreatedAt.Local().Format(time.DateTime)})
+			}
+			table.Render()
+			return nil
+		},
+	})
+	webhookAddCmd := &cobra.Command{
+		Use:   "add <url>",
+		Short: "Add a webhook",
+		Args:  ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			u, err := url.Parse(args[0])
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return fmt.Errorf("invalid webhook url: %s", args[0])
+			}
+			events, err := cmd.Flags().GetStringSlice("events")
+			if err != nil {
+				return err
+			}
+			for _, event := range events {
+				if !slices.Contains(lxc.EventTypes, event) {
+					return fmt.Errorf("unknown event %q, expected one of: %s", event, strings.Join(lxc.EventTypes, ", "))
+				}
+			}
+			secret, err := cmd.Flags().GetString("secret")
+			if err != nil {
+				return err
+			}
+			if secret == "" {
+				buf := make([]byte, 16)
+				if _, err := rand.Read(buf); err != nil {
+					return err
+				}
+				secret = hex.EncodeToString(buf)
+			}
+			id, err := common.AddWebhook(args[0], secret, events)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Webhook %d added, payloads are signed with secret:\n%s\n", id, secret)
+			return nil
+		},
+	}
+	webhookAddCmd.Flags().StringSlice("events", nil, "Events to deliver ("+strings.Join(lxc.EventTypes, ", ")+"), all if empty")
+	webhookAddCmd.Flags().String("secret", "", "HMAC secret used to sign payloads, generated if empty")
+	webhookCmd.AddCommand(webhookAddCmd)
+	webhookCmd.AddCommand(&cobra.Command{
+		Use:   "delete <id>",
+		Short: "Delete a webhook",
+		Args:  ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return err
+			}
+			return common.DeleteWebhook(id)
 		},
 	})
+	webhookDeliveriesCmd := &cobra.Command{
+		Use:   "deliveries [id]",
+		Short: "Show recent webhook deliveries",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var id int64
+			if len(args) > 0 {
+				var err error
+				id, err = strconv.ParseInt(args[0], 10, 64)
+				if err != nil {
+					return err
+				}
+			}
+			limit, err := cmd.Flags().GetInt("limit")
+			if err != nil {
+				return err
+			}
+			deliveries, err := common.ListWebhookDeliveries(id, limit)
+			if err != nil {
+				return err
+			}
+			table := tablewriter.NewWriter(cmd.OutOrStdout())
+			table.SetRowLine(true)
+			table.SetAlignment(tablewriter.ALIGN_LEFT)
+			table.SetHeader([]string{"Webhook", "Event", "Instance", "Attempt", "Status", "Error", "Time"})
+			for _, delivery := range deliveries {
+				status := "-"
+				if delivery.StatusCode != 0 {
+					status = strconv.Itoa(delivery.StatusCode)
+				}
+				table.Append([]string{
+					strconv.FormatInt(delivery.WebhookID, 10),
+					delivery.Event,
+					delivery.Instance,
+					strconv.Itoa(delivery.Attempt),
+					status,
+					delivery.Error,
+					delivery.CreatedAt.Local().Format(time.DateTime),
+				})
+			}
+			table.Render()
+			return nil
+		},
+	}
+	webhookDeliveriesCmd.Flags().IntP("limit", "n", 20, "Number of deliveries to show")
+	webhookCmd.AddCommand(webhookDeliveriesCmd)
 
+	filterCommands(role, &command.cmd)
+	// The commands are filtered by the role at login, the role is checked
+	// again on every run in case it was changed since.
+	command.cmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		current, err := command.ctx.Role()
+		if err != nil {
+			return err
+		}
+		return authorize(current, cmd)
+	}
 	command.cmd.SilenceErrors = true
 	command.cmd.SilenceUsage = true
 	return command
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"lxcpanel/common"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// newAdminBanCmd returns the admin ban command group showing and lifting
+// bans caused by repeated failed logins.
+func newAdminBanCmd() *cobra.Command {
+	banCmd := &cobra.Command{
+		Use:   "ban",
+		Short: "Manage login bans",
+		Long:  "Addresses and usernames with repeated failed logins are banned temporarily, each further failure doubling the ban. Usernames with repeated wrong verification codes are banned from the MFA step the same way.",
+	}
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List active bans",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			all, err := cmd.Flags().GetBool("all")
+			if err != nil {
+				return err
+			}
+			failures, err := common.ListLoginFailures(all)
+			if err != nil {
+				return err
+			}
+			return PrintList(cmd, failures, []Column[common.DBLoginFailure]{
+				{Name: "kind", Header: "Kind", Value: func(f common.DBLoginFailure) string { return f.Kind }},
+				{Name: "value", Header: "Value", Value: func(f common.DBLoginFailure) string { return f.Value }},
+				{Name: "failures", Header: "Failures", Value: func(f common.DBLoginFailure) string { return strconv.Itoa(f.Failures) }},
+				timeColumn("last_failure_at", "Last Failure", "", func(f common.DBLoginFailure) *time.Time { return &f.LastFailureAt }),
+				timeColumn("banned_until", "Banned Until", "", func(f common.DBLoginFailure) *time.Time {
+					if !f.Banned() {
+						return nil
+					}
+					return f.BannedUntil
+				}),
+			})
+		},
+	}
+	listCmd.Flags().Bool("all", false, "Also list failure counters without an active ban")
+	AddFormatFlags(listCmd)
+	banCmd.AddCommand(listCmd)
+	banCmd.AddCommand(&cobra.Command{
+		Use:   "remove <ip|user|mfa> <value>",
+		Short: "Lift a ban and reset the failure counter of an address or username",
+		Args:  ExactArgs(2),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			return []string{common.BanIP, common.BanUser, common.BanMFA}
+		}),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if args[0] != common.BanIP && args[0] != common.BanUser && args[0] != common.BanMFA {
+				return fmt.Errorf("unknown ban kind %q, expected %s, %s or %s", args[0], common.BanIP, common.BanUser, common.BanMFA)
+			}
+			err := common.Unban(args[0], args[1])
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%s %s has no failed logins", args[0], args[1])
+			}
+			return err
+		},
+	})
+	return banCmd
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"lxcpanel/common"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func completeCertAuthorities() []string {
+	cas, err := common.ListCertAuthorities()
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, ca := range cas {
+		names = append(names, ca.Name)
+	}
+	return names
+}
+
+// newAdminCACmd returns the admin ca command group managing trusted SSH user
+// certificate authorities and certificate revocations.
+func newAdminCACmd() *cobra.Command {
+	caCmd := &cobra.Command{
+		Use:   "ca",
+		Short: "Manage trusted SSH user certificate authorities",
+		Long:  "Users can log in with a certificate signed by a trusted CA instead of a registered public key. The login name must be one of the certificate's principals.",
+	}
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List trusted certificate authorities",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cas, err := common.ListCertAuthorities()
+			if err != nil {
+				return err
+			}
+			return PrintList(cmd, cas, []Column[common.DBCertAuthority]{
+				{Name: "name", Header: "Name", Value: func(ca common.DBCertAuthority) string { return ca.Name }},
+				{Name: "fingerprint", Header: "Fingerprint", Value: func(ca common.DBCertAuthority) string { return ca.Fingerprint }},
+				{Name: "pubkey", Header: "Public Key", Value: func(ca common.DBCertAuthority) string { return ca.PEM }, Table: func(ca common.DBCertAuthority) string { return common.WordWrap(ca.PEM, 48) }},
+				timeColumn("created_at", "Created At", "", func(ca common.DBCertAuthority) *time.Time { return &ca.CreatedAt }),
+			})
+		},
+	}
+	AddFormatFlags(listCmd)
+	caCmd.AddCommand(listCmd)
+	caCmd.AddCommand(&cobra.Command{
+		Use:   "add <name> <pubkey>",
+		Short: "Trust a certificate authority",
+		Args:  MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return common.AddCertAuthority(args[0], strings.Join(args[1:], " "))
+		},
+	})
+	caCmd.AddCommand(&cobra.Command{
+		Use:   "remove <name>",
+		Short: "Stop trusting a certificate authority",
+		Args:  ExactArgs(1),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			return completeCertAuthorities()
+		}),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := common.DeleteCertAuthority(args[0])
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("certificate authority %s not found", args[0])
+			}
+			return err
+		},
+	})
+	revocationKinds := completeOnce(func(string) []string {
+		return []string{common.RevokeSerial, common.RevokeKeyID}
+	})
+	caCmd.AddCommand(&cobra.Command{
+		Use:               "revoke <serial|key-id> <value>",
+		Short:             "Revoke certificates by serial or key ID",
+		Long:              "Revoke every certificate with the given serial or key ID, whichever CA signed it.",
+		Args:              ExactArgs(2),
+		ValidArgsFunction: revocationKinds,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return common.RevokeCertificates(args[0], args[1])
+		},
+	})
+	caCmd.AddCommand(&cobra.Command{
+		Use:               "unrevoke <serial|key-id> <value>",
+		Short:             "Remove a certificate revocation",
+		Args:              ExactArgs(2),
+		ValidArgsFunction: revocationKinds,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := common.DeleteCertRevocation(args[0], args[1])
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%s %s is not revoked", args[0], args[1])
+			}
+			return err
+		},
+	})
+	revocationsCmd := &cobra.Command{
+		Use:   "revocations",
+		Short: "List certificate revocations",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			revocations, err := common.ListCertRevocations()
+			if err != nil {
+				return err
+			}
+			return PrintList(cmd, revocations, []Column[common.DBCertRevocation]{
+				{Name: "kind", Header: "Kind", Value: func(r common.DBCertRevocation) string { return r.Kind }},
+				{Name: "value", Header: "Value", Value: func(r common.DBCertRevocation) string { return r.Value }},
+				timeColumn("created_at", "Revoked At", "", func(r common.DBCertRevocation) *time.Time { return &r.CreatedAt }),
+			})
+		},
+	}
+	AddFormatFlags(revocationsCmd)
+	caCmd.AddCommand(revocationsCmd)
+	return caCmd
+}
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"lxcpanel/common"
+	"lxcpanel/lxc"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/spf13/cobra"
+)
+
+type adminInstanceRow struct {
+	Owner        string `json:"owner" yaml:"owner"`
+	OwnerMissing bool   `json:"owner_missing" yaml:"owner_missing"`
+	instanceRow
+}
+
+var adminInstanceColumns = append([]Column[adminInstanceRow]{
+	{Name: "owner", Header: "Owner", Value: func(r adminInstanceRow) string { return r.Owner }, Table: func(r adminInstanceRow) string {
+		if r.OwnerMissing {
+			return r.Owner + " (missing)"
+		}
+		return r.Owner
+	}},
+	{Name: "owner_missing", Header: "Owner Missing", Value: func(r adminInstanceRow) string { return strconv.FormatBool(r.OwnerMissing) }},
+}, adaptColumns(instanceColumns, func(r adminInstanceRow) instanceRow { return r.instanceRow })...)
+
+// knownOwners returns the owners of existing users and teams.
+func knownOwners() (map[string]bool, error) {
+	users, err := common.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	teams, err := common.ListTeams()
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[string]bool, len(users)+len(teams))
+	for _, user := range users {
+		owners[user.Username] = true
+	}
+	for _, team := range teams {
+		owners[common.TeamOwner(team.Name)] = true
+	}
+	return owners, nil
+}
+
+func completeAllContainers(ctx *CommandContext) []string {
+	containers, err := common.Client.ListAllContainers(ctx.Context())
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(containers))
+	for _, container := range containers {
+		names = append(names, container.Name)
+	}
+	return names
+}
+
+// newAdminInstanceCmd returns the admin instance command group acting on the
+// containers of every user. Containers are only identified by name as
+// friendly names are not unique across users.
+func newAdminInstanceCmd(command *adminCmd) *cobra.Command {
+	instanceCmd := &cobra.Command{
+		Use:   "instance",
+		Short: "View and manage the containers of all users",
+	}
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the containers of all users",
+		Long:  "List the containers of all users and teams, including those whose owner no longer exists.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			user, err := cmd.Flags().GetString("user")
+			if err != nil {
+				return err
+			}
+			state, err := cmd.Flags().GetString("state")
+			if err != nil {
+				return err
+			}
+			containers, err := common.Client.ListAllContainers(command.ctx.Context())
+			if err != nil {
+				return err
+			}
+			owners, err := knownOwners()
+			if err != nil {
+				return err
+			}
+			rows := make([]adminInstanceRow, 0, len(containers))
+			for _, container := range containers {
+				owner := container.Config["user.username"]
+				if user != "" && owner != user {
+					continue
+				}
+				if state != "" && !strings.EqualFold(container.Status, state) {
+					continue
+				}
+				rows = append(rows, adminInstanceRow{
+					Owner:        owner,
+					OwnerMissing: !owners[owner],
+					instanceRow:  newInstanceRow(container),
+				})
+			}
+			return PrintList(cmd, rows, adminInstanceColumns)
+		},
+	}
+	listCmd.Flags().String("user", "", "Only list the containers of this owner, team:<name> for a team")
+	listCmd.Flags().String("state", "", "Only list containers in this state, e.g. Running or Stopped")
+	AddFormatFlags(listCmd)
+	instanceCmd.AddCommand(listCmd)
+	completeNames := completeOnce(func(string) []string {
+		return completeAllContainers(command.ctx)
+	})
+	actions := []struct {
+		use, short string
+		run        func(ctx *CommandContext, container *api.Instance) error
+	}{
+		{"start <name>", "Start a container of any user", startAny},
+		{"stop <name>", "Stop a container of any user", stopAny},
+		{"restart <name>", "Stop a container of any user if it is running, then start it", func(ctx *CommandContext, container *api.Instance) error {
+			if container.StatusCode == api.Running {
+				if err := stopAny(ctx, container); err != nil {
+					return err
+				}
+			}
+			return startAny(ctx, container)
+		}},
+	}
+	instanceCmd.AddCommand(&cobra.Command{
+		Use:               "delete <name>",
+		Short:             "Delete a container of any user",
+		Args:              ExactArgs(1),
+		ValidArgsFunction: completeNames,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := common.DeleteAnyContainer(command.ctx.Context(), args[0])
+			if errors.Is(err, lxc.ErrContainerNotFound) {
+				return fmt.Errorf("%s: %w", args[0], err)
+			}
+			return err
+		},
+	})
+	instanceCmd.AddCommand(&cobra.Command{
+		Use:               "shell <name>",
+		Short:             "Open a shell in a container of any user",
+		Args:              ExactArgs(1),
+		ValidArgsFunction: completeNames,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			container, err := common.Client.FindContainer(command.ctx.Context(), args[0])
+			if err != nil {
+				return fmt.Errorf("%s: %w", args[0], err)
+			}
+			return openShell(command.ctx, cmd, container)
+		},
+	})
+	instanceCmd.AddCommand(&cobra.Command{
+		Use:   "transfer <name> <new owner>",
+		Short: "Give a container to another user or team",
+		Long:  "Give a container to another user, or to a team with team:<name>. The quota of the new owner is not checked.",
+		Args:  ExactArgs(2),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 1 {
+				owners := completeUsernames()
+				for _, team := range completeTeamNames() {
+					owners = append(owners, common.TeamOwner(team))
+				}
+				return owners, cobra.ShellCompDirectiveNoFileComp
+			}
+			return completeNames(cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := common.TransferContainer(command.ctx.Context(), args[0], args[1])
+			if errors.Is(err, lxc.ErrContainerNotFound) {
+				return fmt.Errorf("%s: %w", args[0], err)
+			}
+			return err
+		},
+	})
+	for _, action := range actions {
+		instanceCmd.AddCommand(&cobra.Command{
+			Use:               action.use,
+			Short:             action.short,
+			Args:              ExactArgs(1),
+			ValidArgsFunction: completeNames,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				container, err := common.Client.FindContainer(command.ctx.Context(), args[0])
+				if err != nil {
+					return fmt.Errorf("%s: %w", args[0], err)
+				}
+				return action.run(command.ctx, container)
+			},
+		})
+	}
+	return instanceCmd
+}
+
+func startAny(ctx *CommandContext, container *api.Instance) error {
+	return common.Client.StartContainer(ctx.Context(), container.Config["user.username"], container.Name)
+}
+
+func stopAny(ctx *CommandContext, container *api.Instance) error {
+	return common.Client.StopContainer(ctx.Context(), container.Config["user.username"], container.Name)
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"lxcpanel/common"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// newAdminInviteCmd returns the admin invite command group managing invite
+// codes for self-service registration.
+func newAdminInviteCmd(command *adminCmd) *cobra.Command {
+	inviteCmd := &cobra.Command{
+		Use:   "invite",
+		Short: "Manage invite codes for self-service registration",
+		Long:  "Unknown users connecting with an unregistered key can redeem an invite code to create their account, choosing a username and registering the key they logged in with. Each code can be used once.",
+	}
+	createCmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create invite codes",
+		Args:  ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			count, err := cmd.Flags().GetInt("count")
+			if err != nil {
+				return err
+			}
+			if count < 1 || count > common.MaxInviteCount {
+				return fmt.Errorf("count must be between 1 and %d", common.MaxInviteCount)
+			}
+			maxInstanceCount, err := cmd.Flags().GetInt("max-instances")
+			if err != nil {
+				return err
+			}
+			expires, err := cmd.Flags().GetString("expires")
+			if err != nil {
+				return err
+			}
+			expiresAt, err := parseExpiry(expires)
+			if err != nil {
+				return err
+			}
+			codes, err := common.CreateInvites(command.ctx.User(), count, maxInstanceCount, expiresAt)
+			if err != nil {
+				return err
+			}
+			for _, code := range codes {
+				fmt.Fprintln(cmd.OutOrStdout(), code)
+			}
+			return nil
+		},
+	}
+	createCmd.Flags().Int("count", 1, fmt.Sprintf("Number of codes to create, at most %d", common.MaxInviteCount))
+	createCmd.Flags().Int("max-instances", 3, "The maximum number of instances of the created accounts")
+	createCmd.Flags().String("expires", "", "Expiry date, RFC 3339 time or duration such as 7d (default never)")
+	inviteCmd.AddCommand(createCmd)
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List invite codes",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			invites, err := common.ListInvites()
+			if err != nil {
+				return err
+			}
+			return PrintList(cmd, invites, []Column[common.DBInvite]{
+				{Name: "id", Header: "ID", Value: func(i common.DBInvite) string { return strconv.FormatInt(i.ID, 10) }},
+				{Name: "status", Header: "Status", Value: func(i common.DBInvite) string { return i.Status() }},
+				{Name: "max_instance_count", Header: "Max Instances", Value: func(i common.DBInvite) string { return strconv.Itoa(i.MaxInstanceCount) }},
+				{Name: "created_by", Header: "Created By", Value: func(i common.DBInvite) string { return i.CreatedBy }},
+				timeColumn("created_at", "Created At", "", func(i common.DBInvite) *time.Time { return &i.CreatedAt }),
+				timeColumn("expires_at", "Expires At", "never", func(i common.DBInvite) *time.Time { return i.ExpiresAt }),
+				{Name: "used_by", Header: "Used By", Value: func(i common.DBInvite) string { return i.UsedBy }},
+				timeColumn("used_at", "Used At", "", func(i common.DBInvite) *time.Time { return i.UsedAt }),
+			})
+		},
+	}
+	AddFormatFlags(listCmd)
+	inviteCmd.AddCommand(listCmd)
+	inviteCmd.AddCommand(&cobra.Command{
+		Use:   "revoke <id>",
+		Short: "Delete an invite code",
+		Long:  "Delete an invite code so it can't be redeemed. Accounts already created with it are kept.",
+		Args:  ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return err
+			}
+			err = common.RevokeInvite(id)
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("invite %d not found", id)
+			}
+			return err
+		},
+	})
+	return inviteCmd
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"lxcpanel/common"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// addAdminLifecycleCmds adds the suspend, resume and offboard commands to the
+// admin user command group.
+func addAdminLifecycleCmds(command *adminCmd, userCmd *cobra.Command) {
+	completeUsers := completeOnce(func(string) []string {
+		return completeUsernames()
+	})
+	userCmd.AddCommand(&cobra.Command{
+		Use:               "suspend <username> [reason]",
+		Short:             "Deny a user any login and stop their containers",
+		Long:              "Suspended users are shown the reason when trying to log in, their tokens stop working and their open sessions end with the next command. Their containers are stopped but kept.",
+		Args:              MinimumNArgs(1),
+		ValidArgsFunction: completeUsers,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			stopped, err := common.SuspendUser(command.ctx.Context(), args[0], strings.Join(args[1:], " "))
+			for _, name := range stopped {
+				fmt.Fprintf(cmd.OutOrStdout(), "Stopped %s\n", name)
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("user %s not found", args[0])
+			}
+			return err
+		},
+	})
+	userCmd.AddCommand(&cobra.Command{
+		Use:               "resume <username>",
+		Short:             "Lift the suspension of a user, their containers stay stopped",
+		Args:              ExactArgs(1),
+		ValidArgsFunction: completeUsers,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := common.ResumeUser(args[0])
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("user %s not found", args[0])
+			}
+			return err
+		},
+	})
+	offboardCmd := &cobra.Command{
+		Use:               "offboard <username>",
+		Short:             "Remove a user with their containers, keys and settings",
+		Long:              "Suspend the user, optionally export a backup of each container, delete the containers releasing their SSH ports, then delete the user's keys, tokens, settings and account. The steps are recorded in a job shown by \"admin job\". A failed offboarding can be run again to finish it.",
+		Args:              ExactArgs(1),
+		ValidArgsFunction: completeUsers,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			backupDir, err := cmd.Flags().GetString("backup-dir")
+			if err != nil {
+				return err
+			}
+			dryRun, err := cmd.Flags().GetBool("dry-run")
+			if err != nil {
+				return err
+			}
+			id, err := common.OffboardUser(command.ctx.Context(), args[0], command.ctx.User(), common.OffboardOptions{
+				BackupDir: backupDir,
+				DryRun:    dryRun,
+			}, cmd.OutOrStdout())
+			if errors.Is(err, sql.ErrNoRows) && id == 0 {
+				return fmt.Errorf("user %s not found", args[0])
+			}
+			if err != nil && id != 0 {
+				return fmt.Errorf("job %d failed: %w", id, err)
+			}
+			return err
+		},
+	}
+	offboardCmd.Flags().String("backup-dir", "", "Directory on the panel host to export container backups to (default no backups)")
+	offboardCmd.Flags().Bool("dry-run", false, "Only list the steps that would be taken")
+	userCmd.AddCommand(offboardCmd)
+}
+
+// newAdminJobCmd returns the admin job command group showing long-running
+// admin operations such as offboardings.
+func newAdminJobCmd() *cobra.Command {
+	jobCmd := &cobra.Command{
+		Use:   "job",
+		Short: "Show long-running admin operations",
+	}
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List jobs, most recent first",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			jobs, err := common.ListJobs()
+			if err != nil {
+				return err
+			}
+			return PrintList(cmd, jobs, []Column[common.DBJob]{
+				{Name: "id", Header: "ID", Value: func(j common.DBJob) string { return strconv.FormatInt(j.ID, 10) }},
+				{Name: "kind", Header: "Kind", Value: func(j common.DBJob) string { return j.Kind }},
+				{Name: "target", Header: "Target", Value: func(j common.DBJob) string { return j.Target }},
+				{Name: "status", Header: "Status", Value: func(j common.DBJob) string { return j.Status }},
+				{Name: "created_by", Header: "Created By", Value: func(j common.DBJob) string { return j.CreatedBy }},
+				timeColumn("created_at", "Created At", "", func(j common.DBJob) *time.Time { return &j.CreatedAt }),
+				timeColumn("finished_at", "Finished At", "", func(j common.DBJob) *time.Time { return j.FinishedAt }),
+			})
+		},
+	}
+	AddFormatFlags(listCmd)
+	jobCmd.AddCommand(listCmd)
+	jobCmd.AddCommand(&cobra.Command{
+		Use:   "show <id>",
+		Short: "Show the log of a job",
+		Args:  ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return err
+			}
+			job, err := common.GetJob(id)
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("job %d not found", id)
+			}
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Job %d: %s %s by %s, %s\n", job.ID, job.Kind, job.Target, job.CreatedBy, job.Status)
+			fmt.Fprint(out, job.Log)
+			if job.Error != "" {
+				fmt.Fprintf(out, "Error: %s\n", job.Error)
+			}
+			return nil
+		},
+	})
+	return jobCmd
+}
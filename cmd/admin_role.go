@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"lxcpanel/common"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func completeRoles() []string {
+	names := make([]string, 0, len(common.Roles))
+	for _, role := range common.Roles {
+		names = append(names, role.Name)
+	}
+	return names
+}
+
+// newAdminRoleCmd returns the admin role command group describing the roles
+// assigned with "admin user role".
+func newAdminRoleCmd() *cobra.Command {
+	roleCmd := &cobra.Command{
+		Use:   "role",
+		Short: "Show the roles that can be assigned to users",
+	}
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List roles and their permissions",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return PrintList(cmd, common.Roles, []Column[common.Role]{
+				{Name: "name", Header: "Name", Value: func(r common.Role) string { return r.Name }},
+				{Name: "description", Header: "Description", Value: func(r common.Role) string { return r.Description }},
+				{Name: "permissions", Header: "Permissions", Value: func(r common.Role) string {
+					permissions := make([]string, 0, len(r.Permissions))
+					for _, permission := range r.Permissions {
+						permissions = append(permissions, string(permission))
+					}
+					return strings.Join(permissions, ",")
+				}},
+			})
+		},
+	}
+	AddFormatFlags(listCmd)
+	roleCmd.AddCommand(listCmd)
+	return roleCmd
+}
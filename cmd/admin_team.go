@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"lxcpanel/common"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func completeTeamNames() []string {
+	teams, err := common.ListTeams()
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, team := range teams {
+		names = append(names, team.Name)
+	}
+	return names
+}
+
+// newAdminTeamCmd returns the admin team command group creating teams and
+// managing their members and quota.
+func newAdminTeamCmd(command *adminCmd) *cobra.Command {
+	teamCmd := &cobra.Command{
+		Use:   "team",
+		Short: "Manage teams sharing containers",
+	}
+	completeTeams := completeOnce(func(string) []string {
+		return completeTeamNames()
+	})
+	createCmd := &cobra.Command{
+		Use:   "create <name> <owner>",
+		Short: "Create a team",
+		Args:  ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			maxInstanceCount, err := cmd.Flags().GetInt("max-instances")
+			if err != nil {
+				return err
+			}
+			return common.CreateTeam(args[0], args[1], maxInstanceCount, command.ctx.User())
+		},
+	}
+	createCmd.Flags().Int("max-instances", 3, "The maximum number of instances of the team")
+	teamCmd.AddCommand(createCmd)
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List teams",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			teams, err := common.ListTeams()
+			if err != nil {
+				return err
+			}
+			return PrintList(cmd, teams, []Column[common.DBTeam]{
+				{Name: "name", Header: "Name", Value: func(t common.DBTeam) string { return t.Name }},
+				{Name: "max_instance_count", Header: "Max Instance Count", Value: func(t common.DBTeam) string { return strconv.Itoa(t.MaxInstanceCount) }},
+				{Name: "created_by", Header: "Created By", Value: func(t common.DBTeam) string { return t.CreatedBy }},
+				timeColumn("created_at", "Created At", "", func(t common.DBTeam) *time.Time { return &t.CreatedAt }),
+			})
+		},
+	}
+	AddFormatFlags(listCmd)
+	teamCmd.AddCommand(listCmd)
+	membersCmd := &cobra.Command{
+		Use:               "members <team>",
+		Short:             "List the members of a team",
+		Args:              ExactArgs(1),
+		ValidArgsFunction: completeTeams,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := common.GetTeam(args[0]); errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("team %s not found", args[0])
+			} else if err != nil {
+				return err
+			}
+			members, err := common.ListTeamMembers(args[0])
+			if err != nil {
+				return err
+			}
+			return PrintList(cmd, members, teamMemberColumns)
+		},
+	}
+	AddFormatFlags(membersCmd)
+	teamCmd.AddCommand(membersCmd)
+	addCmd := &cobra.Command{
+		Use:               "add <team> <username>",
+		Short:             "Add a member to a team",
+		Long:              "Add a member to a team. Adding an existing member changes whether they are an owner.",
+		Args:              ExactArgs(2),
+		ValidArgsFunction: completeTeams,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, err := cmd.Flags().GetBool("owner")
+			if err != nil {
+				return err
+			}
+			return teamError(common.AddTeamMember(args[0], args[1], owner), args[0], args[1])
+		},
+	}
+	addCmd.Flags().Bool("owner", false, "Make the member an owner of the team")
+	teamCmd.AddCommand(addCmd)
+	teamCmd.AddCommand(&cobra.Command{
+		Use:               "remove <team> <username>",
+		Short:             "Remove a member from a team",
+		Args:              ExactArgs(2),
+		ValidArgsFunction: completeTeams,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return teamError(common.RemoveTeamMember(args[0], args[1]), args[0], args[1])
+		},
+	})
+	teamCmd.AddCommand(&cobra.Command{
+		Use:               "instances <team> <num>",
+		Short:             "Change a team's instance quota",
+		Args:              ExactArgs(2),
+		ValidArgsFunction: completeTeams,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			maxInstanceCount, err := strconv.Atoi(args[1])
+			if err != nil {
+				return err
+			}
+			err = common.ChangeTeamMaxInstanceCount(args[0], maxInstanceCount)
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("team %s not found", args[0])
+			}
+			return err
+		},
+	})
+	teamCmd.AddCommand(&cobra.Command{
+		Use:               "delete <team>",
+		Short:             "Delete a team without containers",
+		Args:              ExactArgs(1),
+		ValidArgsFunction: completeTeams,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := common.DeleteTeam(command.ctx.Context(), args[0])
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("team %s not found", args[0])
+			}
+			return err
+		},
+	})
+	return teamCmd
+}
@@ -0,0 +1,302 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"lxcpanel/common"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+// reservedNames are handled by the REPL itself and cannot be aliased.
+var reservedNames = []string{"exit"}
+
+// newUserAlias resolves expansion against commands. Aliases of aliases are
+// flattened so removing or redefining one never affects another.
+func newUserAlias(commands map[string]Command, expansion string) (*AliasCommand, error) {
+	tokens, err := tokenize(expansion)
+	if err != nil {
+		return nil, err
+	}
+	var words []string
+	for _, t := range tokens {
+		if t.op {
+			return nil, fmt.Errorf("aliases cannot contain %s", t.value)
+		}
+		words = append(words, t.value)
+	}
+	if len(words) == 0 {
+		return nil, errors.New("empty alias expansion")
+	}
+	target, ok := commands[words[0]]
+	if !ok {
+		return nil, fmt.Errorf("%s: command not found", words[0])
+	}
+	args := append([]string(nil), words[1:]...)
+	if alias, ok := target.(*AliasCommand); ok {
+		target = alias.Cmd
+		args = append(append([]string(nil), alias.Args...), args...)
+	}
+	return &AliasCommand{
+		Cmd:  target,
+		Args: args,
+		User: true,
+	}, nil
+}
+
+// isUserAlias reports whether command is an alias defined by the user rather
+// than a built-in command or alias.
+func isUserAlias(command Command) bool {
+	alias, ok := command.(*AliasCommand)
+	return ok && alias.User
+}
+
+// LoadAliases adds the aliases of username to commands. Aliases that no
+// longer resolve, or that a built-in command now shadows, are skipped.
+func LoadAliases(commands map[string]Command, username string) error {
+	aliases, err := common.ListAliases(username)
+	if err != nil {
+		return err
+	}
+	for _, alias := range aliases {
+		if _, ok := commands[alias.Name]; ok {
+			log.Warn("Alias shadowed by a built-in command", "user", username, "alias", alias.Name)
+			continue
+		}
+		command, err := newUserAlias(commands, alias.Expansion)
+		if err != nil {
+			log.Warn("Skipping invalid alias", "user", username, "alias", alias.Name, "error", err)
+			continue
+		}
+		commands[alias.Name] = command
+	}
+	return nil
+}
+
+type aliasCmd struct {
+	cmd      cobra.Command
+	ctx      *CommandContext
+	commands map[string]Command
+}
+
+func (command *aliasCmd) Meta() CommandMeta {
+	return cobraMeta(&command.cmd)
+}
+
+func (command *aliasCmd) Exec(ctx *CommandContext, args []string) error {
+	command.cmd.SetArgs(args[1:])
+	command.cmd.SetIn(ctx)
+	command.cmd.SetOut(ctx)
+	command.cmd.SetErr(ctx)
+	command.ctx = ctx
+	return command.cmd.Execute()
+}
+
+func (command *aliasCmd) Complete(ctx *CommandContext, args []string, toComplete string) []string {
+	command.ctx = ctx
+	return completeCobra(&command.cmd, args, toComplete)
+}
+
+func completeAliases(ctx *CommandContext) []string {
+	aliases, err := common.ListAliases(ctx.User())
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, alias := range aliases {
+		names = append(names, alias.Name)
+	}
+	return names
+}
+
+// NewAliasCmd returns the alias command, which adds and removes the user's
+// aliases in commands as well as in the database.
+func NewAliasCmd(commands map[string]Command) Command {
+	command := &aliasCmd{
+		cmd: cobra.Command{
+			Use:   "alias",
+			Short: "Manage your command aliases",
+		},
+		ctx:      nil,
+		commands: commands,
+	}
+	command.cmd.AddCommand(&cobra.Command{
+		Use:   "set <name> <expansion>",
+		Short: "Create or replace an alias",
+		Long:  "Create or replace an alias, e.g. \"alias set up lxc start dev\". Arguments given to the alias are appended to the expansion.",
+		Args:  MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := command.ctx
+			name := args[0]
+			if strings.ContainsAny(name, " \t!") {
+				return fmt.Errorf("invalid alias name %q", name)
+			}
+			if existing, ok := command.commands[name]; ok && !isUserAlias(existing) || slices.Contains(reservedNames, name) {
+				return fmt.Errorf("%s is a built-in command", name)
+			}
+			expansion := strings.Join(args[1:], " ")
+			alias, err := newUserAlias(command.commands, expansion)
+			if err != nil {
+				return err
+			}
+			if err = common.SetAlias(ctx.User(), name, expansion); err != nil {
+				return err
+			}
+			command.commands[name] = alias
+			return nil
+		},
+	})
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List your aliases",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			aliases, err := common.ListAliases(command.ctx.User())
+			if err != nil {
+				return err
+			}
+			return PrintList(cmd, aliases, []Column[common.DBAlias]{
+				{Name: "name", Header: "Name", Value: func(a common.DBAlias) string { return a.Name }},
+				{Name: "expansion", Header: "Expansion", Value: func(a common.DBAlias) string { return a.Expansion }},
+			})
+		},
+	}
+	AddFormatFlags(listCmd)
+	command.cmd.AddCommand(listCmd)
+	command.cmd.AddCommand(&cobra.Command{
+		Use:   "remove <name>",
+		Short: "Remove an alias",
+		Args:  ExactArgs(1),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			return completeAliases(command.ctx)
+		}),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := common.DeleteAlias(command.ctx.User(), args[0])
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("alias %s not found", args[0])
+			}
+			if err != nil {
+				return err
+			}
+			if isUserAlias(command.commands[args[0]]) {
+				delete(command.commands, args[0])
+			}
+			return nil
+		},
+	})
+	command.cmd.SilenceUsage = true
+	command.cmd.SilenceErrors = true
+	return command
+}
+
+type startupCmd struct {
+	cmd cobra.Command
+	ctx *CommandContext
+}
+
+func (command *startupCmd) Meta() CommandMeta {
+	return cobraMeta(&command.cmd)
+}
+
+func (command *startupCmd) Exec(ctx *CommandContext, args []string) error {
+	command.cmd.SetArgs(args[1:])
+	command.cmd.SetIn(ctx)
+	command.cmd.SetOut(ctx)
+	command.cmd.SetErr(ctx)
+	command.ctx = ctx
+	return command.cmd.Execute()
+}
+
+func (command *startupCmd) Complete(ctx *CommandContext, args []string, toComplete string) []string {
+	command.ctx = ctx
+	return completeCobra(&command.cmd, args, toComplete)
+}
+
+func NewStartupCmd() Command {
+	command := &startupCmd{
+		cmd: cobra.Command{
+			Use:   "startup",
+			Short: "Manage the commands run when you log in",
+		},
+		ctx: nil,
+	}
+	command.cmd.AddCommand(&cobra.Command{
+		Use:   "add <command line>",
+		Short: "Run a command line on every login",
+		Long:  "Run a command line on every login, e.g. \"startup add lxc start dev\".",
+		Args:  MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := common.AddStartupCommand(command.ctx.User(), strings.Join(args, " "))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Startup command %d added\n", id)
+			return nil
+		},
+	})
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List your startup commands",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			commands, err := common.ListStartupCommands(command.ctx.User())
+			if err != nil {
+				return err
+			}
+			return PrintList(cmd, commands, []Column[common.DBStartupCommand]{
+				{Name: "id", Header: "ID", Value: func(c common.DBStartupCommand) string { return strconv.FormatInt(c.ID, 10) }},
+				{Name: "line", Header: "Command", Value: func(c common.DBStartupCommand) string { return c.Line }},
+			})
+		},
+	}
+	AddFormatFlags(listCmd)
+	command.cmd.AddCommand(listCmd)
+	command.cmd.AddCommand(&cobra.Command{
+		Use:   "remove <id>",
+		Short: "Remove a startup command",
+		Args:  ExactArgs(1),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			commands, err := common.ListStartupCommands(command.ctx.User())
+			if err != nil {
+				return nil
+			}
+			var ids []string
+			for _, c := range commands {
+				ids = append(ids, strconv.FormatInt(c.ID, 10))
+			}
+			return ids
+		}),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return err
+			}
+			err = common.DeleteStartupCommand(command.ctx.User(), id)
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("startup command %d not found", id)
+			}
+			return err
+		},
+	})
+	command.cmd.SilenceUsage = true
+	command.cmd.SilenceErrors = true
+	return command
+}
+
+// RunStartupCommands runs the startup commands of the session's user,
+// echoing each line after prompt as if it had been typed.
+func RunStartupCommands(ctx *CommandContext, commands map[string]Command, prompt string, out io.Writer) error {
+	startup, err := common.ListStartupCommands(ctx.User())
+	if err != nil {
+		return err
+	}
+	for _, command := range startup {
+		fmt.Fprintf(out, "%s%s\n", prompt, command.Line)
+		RunLine(ctx, commands, command.Line, out)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"lxcpanel/common"
+
+	"github.com/spf13/cobra"
+)
+
+// commandPermissions maps admin command paths to the permission they
+// require. Subcommands inherit the permission of their closest listed
+// ancestor, commands without any require common.PermAll. An empty
+// permission only requires access to the admin command.
+var commandPermissions = map[string]common.Permission{
+	"admin help":       "",
+	"admin completion": "",
+
+	"admin user":         common.PermUsersManage,
+	"admin user list":    common.PermUsersView,
+	"admin role":         common.PermUsersView,
+	"admin job":          common.PermUsersView,
+	"admin team":         common.PermUsersManage,
+	"admin team list":    common.PermUsersView,
+	"admin team members": common.PermUsersView,
+
+	"admin pubkey":      common.PermKeysManage,
+	"admin pubkey list": common.PermKeysView,
+	"admin pubkey show": common.PermKeysView,
+
+	"admin instance":          common.PermInstancesOperate,
+	"admin instance list":     common.PermInstancesView,
+	"admin instance delete":   common.PermInstancesManage,
+	"admin instance shell":    common.PermInstancesManage,
+	"admin instance transfer": common.PermInstancesManage,
+
+	"admin ca":             common.PermAuthManage,
+	"admin ca list":        common.PermAuthView,
+	"admin ca revocations": common.PermAuthView,
+	"admin invite":         common.PermAuthManage,
+	"admin invite list":    common.PermAuthView,
+	"admin ban":            common.PermAuthManage,
+	"admin ban list":       common.PermAuthView,
+
+	"admin webhook": common.PermWebhooksManage,
+}
+
+// requiredPermission returns the permission needed to run cmd.
+func requiredPermission(cmd *cobra.Command) common.Permission {
+	for c := cmd; c != nil; c = c.Parent() {
+		if permission, ok := commandPermissions[c.CommandPath()]; ok {
+			return permission
+		}
+	}
+	return common.PermAll
+}
+
+// authorize checks that role may run cmd.
+func authorize(role common.Role, cmd *cobra.Command) error {
+	permission := requiredPermission(cmd)
+	if permission != "" && !role.Has(permission) {
+		return fmt.Errorf("permission denied: %s requires the %s permission", cmd.CommandPath(), permission)
+	}
+	return nil
+}
+
+// filterCommands removes the subcommands of cmd that role may not run, and
+// the command groups left empty, so they don't show up in help or
+// completion. It reports whether cmd itself is left usable.
+func filterCommands(role common.Role, cmd *cobra.Command) bool {
+	for _, sub := range cmd.Commands() {
+		if !filterCommands(role, sub) {
+			cmd.RemoveCommand(sub)
+		}
+	}
+	if cmd.Runnable() {
+		return authorize(role, cmd) == nil
+	}
+	return cmd.HasSubCommands()
+}
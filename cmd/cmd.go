@@ -1,10 +1,15 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"lxcpanel/common"
 	"net"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/charmbracelet/ssh"
 	"github.com/spf13/cobra"
@@ -12,17 +17,56 @@ import (
 
 type Command interface {
 	Exec(ctx *CommandContext, args []string) error
+	Meta() CommandMeta
+}
+
+// CommandMeta describes a command for the help command and the generated
+// command reference.
+type CommandMeta struct {
+	Short string
+	Long  string
+	// Cobra is the command tree of cobra based commands, nil otherwise.
+	Cobra *cobra.Command
+}
+
+func cobraMeta(cmd *cobra.Command) CommandMeta {
+	return CommandMeta{
+		Short: cmd.Short,
+		Long:  cmd.Long,
+		Cobra: cmd,
+	}
 }
 
 type AliasCommand struct {
 	Cmd  Command
 	Args []string
+	// User is set on the aliases users define with the alias command.
+	User bool
+}
+
+// windowChangeHandler is a function registered with OnWindowChange.
+type windowChangeHandler struct {
+	id int
+	f  func(ssh.Window)
 }
 
 type CommandContext struct {
-	sess                ssh.Session
-	windowChangeHanders []func(ssh.Window)
-	reader              *common.InterruptibleReader
+	sess ssh.Session
+	// windowLock guards windowHandlers and nextWindowHandler, which are
+	// changed by commands while monitorWindow runs.
+	windowLock        sync.Mutex
+	windowHandlers    []windowChangeHandler
+	nextWindowHandler int
+	reader            *common.InterruptibleReader
+	history           *History
+	// out, if set, receives the command output instead of the session.
+	out io.Writer
+	// cmdCtx is cancelled when the running command is interrupted.
+	cmdCtx context.Context
+	// mfaVerified is set if the session passed MFA.
+	mfaVerified bool
+	// role looks up the current role of the user.
+	role func() (common.Role, error)
 }
 
 func NewCommandContext(sess ssh.Session) *CommandContext {
@@ -41,15 +85,30 @@ func (s *CommandContext) monitorWindow() {
 		if !ok {
 			return
 		}
-		for _, f := range s.windowChangeHanders {
-			f(window)
+		s.windowLock.Lock()
+		handlers := slices.Clone(s.windowHandlers)
+		s.windowLock.Unlock()
+		for _, handler := range handlers {
+			handler.f(window)
 		}
 	}
 }
 
-func (s *CommandContext) OnWindowChange(f func(ssh.Window)) int {
-	s.windowChangeHanders = append(s.windowChangeHanders, f)
-	return len(s.windowChangeHanders) - 1
+// OnWindowChange calls f whenever the terminal is resized, until the returned
+// function is called. f must not block.
+func (s *CommandContext) OnWindowChange(f func(ssh.Window)) (remove func()) {
+	s.windowLock.Lock()
+	defer s.windowLock.Unlock()
+	id := s.nextWindowHandler
+	s.nextWindowHandler++
+	s.windowHandlers = append(s.windowHandlers, windowChangeHandler{id: id, f: f})
+	return func() {
+		s.windowLock.Lock()
+		defer s.windowLock.Unlock()
+		s.windowHandlers = slices.DeleteFunc(s.windowHandlers, func(handler windowChangeHandler) bool {
+			return handler.id == id
+		})
+	}
 }
 
 func (s *CommandContext) WindowSize() (int, int) {
@@ -57,10 +116,6 @@ func (s *CommandContext) WindowSize() (int, int) {
 	return pty.Window.Width, pty.Window.Height
 }
 
-func (s *CommandContext) RemoveWindowChangeHandler(id int) {
-	s.windowChangeHanders = append(s.windowChangeHanders[:id], s.windowChangeHanders[id+1:]...)
-}
-
 func (s *CommandContext) SendEOF() {
 	s.reader.SendEOF()
 }
@@ -70,9 +125,22 @@ func (s *CommandContext) Read(p []byte) (n int, err error) {
 }
 
 func (s *CommandContext) Write(p []byte) (n int, err error) {
+	if s.out != nil {
+		return s.out.Write(p)
+	}
 	return s.sess.Write(p)
 }
 
+// redirect sends the command output to w until the returned function is
+// called.
+func (s *CommandContext) redirect(w io.Writer) func() {
+	prev := s.out
+	s.out = w
+	return func() {
+		s.out = prev
+	}
+}
+
 func (s *CommandContext) IP() string {
 	addr := s.sess.LocalAddr()
 	host, _, err := net.SplitHostPort(addr.String())
@@ -86,6 +154,86 @@ func (s *CommandContext) User() string {
 	return s.sess.User()
 }
 
+// Context returns the context of the running command, which is cancelled
+// when the user presses Ctrl-C or the session is closed.
+func (s *CommandContext) Context() context.Context {
+	if s.cmdCtx != nil {
+		return s.cmdCtx
+	}
+	return s.sess.Context()
+}
+
+// begin starts a command, cancelling its context on Ctrl-C until the
+// returned function is called.
+func (s *CommandContext) begin() func() {
+	ctx, cancel := context.WithCancel(s.sess.Context())
+	s.cmdCtx = ctx
+	s.reader.SetInterruptHandler(cancel)
+	return func() {
+		s.reader.SetInterruptHandler(nil)
+		s.cmdCtx = nil
+		cancel()
+	}
+}
+
+// ForwardInterrupts passes Ctrl-C on to the input of the running command
+// instead of cancelling it, e.g. for interactive shells.
+func (s *CommandContext) ForwardInterrupts() {
+	s.reader.SetInterruptHandler(nil)
+}
+
+// interrupted reports whether err is the result of the user interrupting the
+// command, as opposed to the session being closed.
+func (s *CommandContext) interrupted(err error) bool {
+	return errors.Is(err, context.Canceled) && s.sess.Context().Err() == nil
+}
+
+// keyPress returns a channel closed when a key is pressed. stop releases the
+// pending read and must be called before returning to the REPL.
+func (s *CommandContext) keyPress() (pressed <-chan struct{}, stop func()) {
+	ch := make(chan struct{})
+	go func() {
+		buf := make([]byte, 1)
+		s.Read(buf)
+		close(ch)
+	}()
+	return ch, func() {
+		s.reader.CancelRead(ch)
+	}
+}
+
+func (s *CommandContext) SetHistory(history *History) {
+	s.history = history
+}
+
+// History returns the session's command history, nil if none was loaded.
+func (s *CommandContext) History() *History {
+	return s.history
+}
+
+func (s *CommandContext) SetMFAVerified(verified bool) {
+	s.mfaVerified = verified
+}
+
+// MFAVerified reports whether the session passed MFA.
+func (s *CommandContext) MFAVerified() bool {
+	return s.mfaVerified
+}
+
+// SetRoleFunc sets how the current role of the user is looked up.
+func (s *CommandContext) SetRoleFunc(role func() (common.Role, error)) {
+	s.role = role
+}
+
+// Role returns the current role of the user, which may have changed since
+// the session started.
+func (s *CommandContext) Role() (common.Role, error) {
+	if s.role == nil {
+		return common.Role{}, errors.New("role of the session is unknown")
+	}
+	return s.role()
+}
+
 func ExactArgs(n int) cobra.PositionalArgs {
 	return func(cmd *cobra.Command, args []string) error {
 		if len(args) != n {
@@ -108,13 +256,20 @@ func MinimumNArgs(n int) cobra.PositionalArgs {
 	}
 }
 
-func BuildCmdList(isAdmin bool) map[string]Command {
+// BuildCmdList returns the commands available to role. The admin command is
+// only added for roles with elevated permissions.
+func BuildCmdList(role common.Role) map[string]Command {
 	lxc := NewLxcCmd()
 	commands := map[string]Command{
-		"pubkey": NewPubkeyCmd(),
-		"lxc":    lxc,
-		"ip":     &ipCmd{},
-		"whoami": &whoamiCmd{},
+		"pubkey":   NewPubkeyCmd(),
+		"token":    NewTokenCmd(),
+		"lxc":      lxc,
+		"ip":       &ipCmd{},
+		"whoami":   NewWhoamiCmd(),
+		"weblogin": &webloginCmd{},
+		"history":  NewHistoryCmd(),
+		"mfa":      NewMfaCmd(),
+		"team":     NewTeamCmd(),
 		"ls": &AliasCommand{
 			Cmd:  lxc,
 			Args: []string{"list"},
@@ -124,38 +279,28 @@ func BuildCmdList(isAdmin bool) map[string]Command {
 			Args: []string{"shell"},
 		},
 	}
-	if isAdmin {
-		commands["admin"] = NewAdminCmd()
+	if role.Elevated() {
+		commands["admin"] = NewAdminCmd(role)
 	}
+	commands["alias"] = NewAliasCmd(commands)
+	commands["startup"] = NewStartupCmd()
+	commands["help"] = &helpCmd{commands: commands}
 	return commands
 }
 
-func BuildCompletionFunc(commands map[string]Command) func(line string, pos int, key rune) (newLine string, newPos int, ok bool) {
-	return func(line string, pos int, key rune) (newLine string, newPos int, ok bool) {
-		if key == '\t' && pos > 0 {
-			line = strings.TrimLeft(line, " ")
-			pos -= len(line) - len(strings.TrimLeft(line, " "))
-
-			firstSpace := strings.Index(line, " ")
-			var prefix string
-			if firstSpace == -1 {
-				prefix = line
-			} else {
-				prefix = line[:firstSpace]
-			}
-
-			for command := range commands {
-				if strings.HasPrefix(command, prefix) {
-					if firstSpace == -1 {
-						return command, len(command), true
-					} else {
-						return command + line[firstSpace:], pos, true
-					}
-				}
-			}
+func (command *AliasCommand) Meta() CommandMeta {
+	meta := command.Cmd.Meta()
+	target := strings.Join(command.Args, " ")
+	if meta.Cobra != nil {
+		target = meta.Cobra.Name() + " " + target
+		if sub, _, err := meta.Cobra.Find(command.Args); err == nil {
+			meta = cobraMeta(sub)
 		}
-
-		return line, pos, false
+	}
+	return CommandMeta{
+		Short: "Alias for " + target,
+		Long:  meta.Long,
+		Cobra: meta.Cobra,
 	}
 }
 
@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"lxcpanel/common"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Completer is implemented by commands that can suggest values for their
+// next argument.
+type Completer interface {
+	Complete(ctx *CommandContext, args []string, toComplete string) []string
+}
+
+func (command *AliasCommand) Complete(ctx *CommandContext, args []string, toComplete string) []string {
+	completer, ok := command.Cmd.(Completer)
+	if !ok {
+		return nil
+	}
+	return completer.Complete(ctx, append(append([]string{}, command.Args...), args...), toComplete)
+}
+
+// completeCobra suggests subcommands, flags or, through ValidArgsFunction,
+// dynamic values for the command tree rooted at root.
+func completeCobra(root *cobra.Command, args []string, toComplete string) []string {
+	cmd, rest, err := root.Find(args)
+	if err != nil {
+		return nil
+	}
+	var candidates []string
+	if strings.HasPrefix(toComplete, "-") {
+		addFlag := func(flag *pflag.Flag) {
+			if flag.Hidden {
+				return
+			}
+			candidates = append(candidates, "--"+flag.Name)
+			if flag.Shorthand != "" {
+				candidates = append(candidates, "-"+flag.Shorthand)
+			}
+		}
+		cmd.NonInheritedFlags().VisitAll(addFlag)
+		cmd.InheritedFlags().VisitAll(addFlag)
+		return candidates
+	}
+	// Don't complete the value of a flag
+	if len(rest) > 0 && strings.HasPrefix(rest[len(rest)-1], "-") && !strings.Contains(rest[len(rest)-1], "=") {
+		name := strings.TrimLeft(rest[len(rest)-1], "-")
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil && len(name) == 1 {
+			flag = cmd.Flags().ShorthandLookup(name)
+		}
+		if flag != nil && flag.NoOptDefVal == "" {
+			return nil
+		}
+	}
+	var positional []string
+	for _, arg := range rest {
+		if !strings.HasPrefix(arg, "-") {
+			positional = append(positional, arg)
+		}
+	}
+	if cmd.HasAvailableSubCommands() && len(positional) == 0 {
+		for _, sub := range cmd.Commands() {
+			if sub.IsAvailableCommand() {
+				candidates = append(candidates, sub.Name())
+			}
+		}
+		return candidates
+	}
+	if cmd.ValidArgsFunction != nil {
+		candidates, _ = cmd.ValidArgsFunction(cmd, positional, toComplete)
+	}
+	return candidates
+}
+
+// completeOnce wraps f so it only completes the first positional argument.
+func completeOnce(f func(toComplete string) []string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return f(toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func completeContainers(ctx *CommandContext) []string {
+	containers, err := common.ListAccessibleContainers(ctx.Context(), ctx.User())
+	if err != nil {
+		return nil
+	}
+	shared, err := common.ListSharedContainers(ctx.Context(), ctx.User())
+	if err != nil {
+		return nil
+	}
+	for _, share := range shared {
+		containers = append(containers, share.Container)
+	}
+	var names []string
+	for _, container := range containers {
+		names = append(names, container.Name)
+		if friendlyname := container.Config["user.friendlyname"]; friendlyname != "" {
+			names = append(names, friendlyname)
+		}
+	}
+	return names
+}
+
+func completeUserTeams(ctx *CommandContext) []string {
+	teams, err := common.ListUserTeams(ctx.User())
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, team := range teams {
+		names = append(names, team.Name)
+	}
+	return names
+}
+
+func completeUsernames() []string {
+	users, err := common.ListUsers()
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, user := range users {
+		names = append(names, user.Username)
+	}
+	return names
+}
+
+func completeFingerprints(keys []common.DBPubKey) []string {
+	var fingerprints []string
+	for _, key := range keys {
+		fingerprints = append(fingerprints, shortFingerprint(key.Fingerprint))
+	}
+	return fingerprints
+}
+
+// longestCommonPrefix returns the longest prefix shared by all candidates.
+func longestCommonPrefix(candidates []string) string {
+	prefix := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// printCandidates lists candidates in columns fitting the terminal width.
+func printCandidates(out io.Writer, candidates []string, width int) {
+	colWidth := 0
+	for _, candidate := range candidates {
+		colWidth = max(colWidth, len(candidate)+2)
+	}
+	perLine := max(1, width/colWidth)
+	var b strings.Builder
+	for i, candidate := range candidates {
+		if i > 0 && i%perLine == 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%-*s", colWidth, candidate)
+	}
+	b.WriteString("\n")
+	io.WriteString(out, b.String())
+}
+
+// BuildCompletionFunc returns a term.Terminal AutoCompleteCallback completing
+// command names and, for commands implementing Completer, their arguments.
+// Pressing tab twice on an ambiguous word lists the candidates on out.
+func BuildCompletionFunc(ctx *CommandContext, commands map[string]Command, out io.Writer) func(line string, pos int, key rune) (newLine string, newPos int, ok bool) {
+	lastLine, lastPos := "", -1
+	return func(line string, pos int, key rune) (newLine string, newPos int, ok bool) {
+		if key != '\t' {
+			lastPos = -1
+			return line, pos, false
+		}
+		doubleTab := line == lastLine && pos == lastPos
+		lastLine, lastPos = line, pos
+
+		before, after := line[:pos], line[pos:]
+		words := strings.Fields(before)
+		toComplete := ""
+		if len(words) > 0 && !strings.HasSuffix(before, " ") {
+			toComplete = words[len(words)-1]
+			words = words[:len(words)-1]
+		}
+
+		// Only the current command of a sequence or pipeline is completed
+		afterPipe := false
+		for i := len(words) - 1; i >= 0; i-- {
+			if words[i] == opPipe || words[i] == opSeq || words[i] == opAnd {
+				afterPipe = words[i] == opPipe
+				words = words[i+1:]
+				break
+			}
+		}
+
+		var candidates []string
+		if afterPipe {
+			if len(words) == 0 {
+				for name := range filters {
+					candidates = append(candidates, name)
+				}
+			}
+		} else if len(words) == 0 {
+			for name := range commands {
+				candidates = append(candidates, name)
+			}
+		} else if completer, ok := commands[words[0]].(Completer); ok {
+			candidates = completer.Complete(ctx, words[1:], toComplete)
+		}
+		candidates = slices.DeleteFunc(candidates, func(c string) bool {
+			return !strings.HasPrefix(c, toComplete)
+		})
+		slices.Sort(candidates)
+		candidates = slices.Compact(candidates)
+
+		if len(candidates) == 0 {
+			return line, pos, true
+		}
+		completed := longestCommonPrefix(candidates)
+		if len(candidates) == 1 && !strings.HasPrefix(after, " ") {
+			completed += " "
+		}
+		if completed == toComplete {
+			if doubleTab && len(candidates) > 1 {
+				width, _ := ctx.WindowSize()
+				printCandidates(out, candidates, width)
+			}
+			return line, pos, true
+		}
+		newLine = before[:len(before)-len(toComplete)] + completed + after
+		newPos = pos - len(toComplete) + len(completed)
+		// A further tab on the completed line lists the remaining candidates
+		lastLine, lastPos = newLine, newPos
+		return newLine, newPos, true
+	}
+}
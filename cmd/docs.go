@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"lxcpanel/common"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// WriteReference writes a Markdown reference of every panel command, built
+// from the same metadata as the help command.
+func WriteReference(w io.Writer) {
+	admin, _ := common.LookupRole(common.RoleAdmin)
+	user, _ := common.LookupRole(common.RoleUser)
+	all := BuildCmdList(admin)
+	regular := BuildCmdList(user)
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	fmt.Fprintln(w, "# Command reference")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "| Command | Description |")
+	fmt.Fprintln(w, "| --- | --- |")
+	for _, name := range names {
+		short := all[name].Meta().Short
+		if _, ok := regular[name]; !ok {
+			short += " (admin only)"
+		}
+		fmt.Fprintf(w, "| [`%s`](#%s) | %s |\n", name, name, short)
+	}
+	for _, name := range names {
+		command := all[name]
+		meta := command.Meta()
+		fmt.Fprintf(w, "\n## %s\n\n", name)
+		if _, ok := regular[name]; !ok {
+			fmt.Fprintln(w, "*Admin only.*")
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, meta.Short+".")
+		if meta.Long != "" {
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, meta.Long)
+		}
+		if _, ok := command.(*AliasCommand); ok || meta.Cobra == nil {
+			continue
+		}
+		writeCobraReference(w, meta.Cobra)
+	}
+}
+
+func writeCobraReference(w io.Writer, cmd *cobra.Command) {
+	if cmd.Runnable() {
+		fmt.Fprintf(w, "\n```\n%s\n```\n", cmd.UseLine())
+		if flags := cmd.NonInheritedFlags(); flags.HasAvailableFlags() {
+			fmt.Fprintf(w, "\nFlags:\n\n```\n%s```\n", flags.FlagUsages())
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		if !sub.IsAvailableCommand() {
+			continue
+		}
+		level := min(6, strings.Count(sub.CommandPath(), " ")+2)
+		fmt.Fprintf(w, "\n%s %s\n\n", strings.Repeat("#", level), sub.CommandPath())
+		if sub.Root().Name() == "admin" {
+			if permission := requiredPermission(sub); permission != "" {
+				fmt.Fprintf(w, "*Requires the `%s` permission.*\n\n", permission)
+			}
+		}
+		if sub.Short != "" {
+			fmt.Fprintln(w, sub.Short+".")
+		}
+		if sub.Long != "" {
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, sub.Long)
+		}
+		writeCobraReference(w, sub)
+	}
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type helpCmd struct {
+	commands map[string]Command
+}
+
+func (cmd *helpCmd) Meta() CommandMeta {
+	return CommandMeta{
+		Short: "List commands or show help for a command",
+		Long:  "Usage: help [command] [subcommand...]",
+	}
+}
+
+func (cmd *helpCmd) Exec(ctx *CommandContext, args []string) error {
+	if len(args) < 2 {
+		names := make([]string, 0, len(cmd.commands))
+		width := 0
+		for name := range cmd.commands {
+			names = append(names, name)
+			width = max(width, len(name))
+		}
+		slices.Sort(names)
+		fmt.Fprintln(ctx, "Available commands:")
+		for _, name := range names {
+			fmt.Fprintf(ctx, "  %-*s  %s\n", width, name, cmd.commands[name].Meta().Short)
+		}
+		fmt.Fprintln(ctx)
+		fmt.Fprintln(ctx, `Use "help <command>" for more information about a command, "exit" to quit.`)
+		fmt.Fprintln(ctx, `Commands can be chained with ";" and "&&", and their output piped into grep, head, tail, wc and sort.`)
+		return nil
+	}
+	command, ok := cmd.commands[args[1]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[1])
+	}
+	meta := command.Meta()
+	if meta.Cobra == nil {
+		fmt.Fprintf(ctx, "%s - %s\n", args[1], meta.Short)
+		if meta.Long != "" {
+			fmt.Fprintln(ctx, meta.Long)
+		}
+		return nil
+	}
+	target := meta.Cobra
+	if alias, ok := command.(*AliasCommand); ok {
+		fmt.Fprintf(ctx, "%s: %s\n\n", args[1], meta.Short)
+		if sub, _, err := target.Find(alias.Args); err == nil {
+			target = sub
+		}
+	}
+	if sub, _, err := target.Find(args[2:]); err == nil {
+		target = sub
+	}
+	target.SetOut(ctx)
+	target.SetErr(ctx)
+	return target.Help()
+}
+
+func (cmd *helpCmd) Complete(ctx *CommandContext, args []string, toComplete string) []string {
+	if len(args) == 0 {
+		var names []string
+		for name := range cmd.commands {
+			names = append(names, name)
+		}
+		return names
+	}
+	command, ok := cmd.commands[args[0]]
+	if !ok {
+		return nil
+	}
+	meta := command.Meta()
+	if meta.Cobra == nil {
+		return nil
+	}
+	target, rest, err := meta.Cobra.Find(args[1:])
+	if err != nil || len(rest) > 0 {
+		return nil
+	}
+	var names []string
+	for _, sub := range target.Commands() {
+		if sub.IsAvailableCommand() && strings.HasPrefix(sub.Name(), toComplete) {
+			names = append(names, sub.Name())
+		}
+	}
+	return names
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"fmt"
+	"lxcpanel/common"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+// redactedCommands lists command prefixes whose remaining arguments are
+// secrets and are not persisted when redaction is enabled.
+var redactedCommands = [][]string{
+	{"pubkey", "add"},
+	{"admin", "pubkey", "add", "*"},
+}
+
+// History is a term.History persisting a user's REPL history in the database.
+type History struct {
+	username string
+	entries  []string
+	size     int
+	redact   bool
+}
+
+// LoadHistory loads the last size lines of username's history.
+func LoadHistory(username string, size int, redact bool) (*History, error) {
+	entries, err := common.ListHistory(username, size)
+	if err != nil {
+		return nil, err
+	}
+	return &History{
+		username: username,
+		entries:  entries,
+		size:     size,
+		redact:   redact,
+	}, nil
+}
+
+// Add records entry. History expansions (!n) are skipped, the expanded line
+// is added instead once it has been resolved.
+func (h *History) Add(entry string) {
+	entry = strings.TrimSpace(entry)
+	if entry == "" || strings.HasPrefix(entry, "!") {
+		return
+	}
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > h.size {
+		h.entries = h.entries[len(h.entries)-h.size:]
+	}
+	stored := entry
+	if h.redact {
+		stored = redact(entry)
+	}
+	if err := common.AddHistory(h.username, stored, h.size); err != nil {
+		log.Error("Error saving history", "user", h.username, "error", err)
+	}
+}
+
+func (h *History) Len() int {
+	return len(h.entries)
+}
+
+// At returns the entry idx lines back, 0 being the most recent.
+func (h *History) At(idx int) string {
+	return h.entries[len(h.entries)-1-idx]
+}
+
+// Expand resolves a history reference: !! is the last line and !n the n-th
+// line as numbered by the history command.
+func (h *History) Expand(line string) (string, error) {
+	ref := strings.TrimPrefix(line, "!")
+	if ref == "!" {
+		if len(h.entries) == 0 {
+			return "", fmt.Errorf("%s: event not found", line)
+		}
+		return h.entries[len(h.entries)-1], nil
+	}
+	n, err := strconv.Atoi(ref)
+	if err != nil || n < 1 || n > len(h.entries) {
+		return "", fmt.Errorf("%s: event not found", line)
+	}
+	return h.entries[n-1], nil
+}
+
+func (h *History) Clear() error {
+	h.entries = nil
+	return common.ClearHistory(h.username)
+}
+
+func redact(line string) string {
+	fields := strings.Fields(line)
+	for _, prefix := range redactedCommands {
+		if len(fields) <= len(prefix) {
+			continue
+		}
+		match := true
+		for i, word := range prefix {
+			if word != "*" && fields[i] != word {
+				match = false
+				break
+			}
+		}
+		if match {
+			return strings.Join(fields[:len(prefix)], " ") + " <redacted>"
+		}
+	}
+	return line
+}
+
+type historyCmd struct {
+	cmd cobra.Command
+	ctx *CommandContext
+}
+
+func (command *historyCmd) Meta() CommandMeta {
+	return cobraMeta(&command.cmd)
+}
+
+func (command *historyCmd) Exec(ctx *CommandContext, args []string) error {
+	command.cmd.SetArgs(args[1:])
+	command.cmd.SetIn(ctx)
+	command.cmd.SetOut(ctx)
+	command.cmd.SetErr(ctx)
+	command.ctx = ctx
+	return command.cmd.Execute()
+}
+
+func NewHistoryCmd() Command {
+	command := &historyCmd{
+		cmd: cobra.Command{
+			Use:   "history [n]",
+			Short: "Show the command history, !n runs line n again and !! the last line",
+			Args:  cobra.MaximumNArgs(1),
+		},
+		ctx: nil,
+	}
+	command.cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		history := command.ctx.History()
+		if history == nil {
+			return fmt.Errorf("history is not available")
+		}
+		clear, err := cmd.Flags().GetBool("clear")
+		if err != nil {
+			return err
+		}
+		if clear {
+			return history.Clear()
+		}
+		start := 0
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil {
+				return err
+			}
+			start = max(0, len(history.entries)-n)
+		}
+		for i := start; i < len(history.entries); i++ {
+			fmt.Fprintf(cmd.OutOrStdout(), "%5d  %s\n", i+1, history.entries[i])
+		}
+		return nil
+	}
+	command.cmd.Flags().BoolP("clear", "c", false, "Clear the history")
+	command.cmd.SilenceUsage = true
+	command.cmd.SilenceErrors = true
+	return command
+}
@@ -4,6 +4,12 @@ import "fmt"
 
 type ipCmd struct{}
 
+func (cmd *ipCmd) Meta() CommandMeta {
+	return CommandMeta{
+		Short: "Show the IP address of the panel",
+	}
+}
+
 func (cmd *ipCmd) Exec(ctx *CommandContext, args []string) error {
 	fmt.Fprintf(ctx, "%s\n", ctx.IP())
 	return nil
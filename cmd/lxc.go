@@ -1,23 +1,151 @@
 package cmd
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
 	"lxcpanel/common"
+	"lxcpanel/lxc"
+	"slices"
 	"strconv"
+	"time"
 
+	lxd "github.com/canonical/lxd/client"
 	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/lxd/shared/units"
 	"github.com/charmbracelet/ssh"
-	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
 )
 
+// containerInfo is the output of lxc info: the container followed by its
+// resource usage in human readable units.
+type containerInfo struct {
+	*api.Instance `yaml:",inline"`
+	State         containerUsage `yaml:"state"`
+}
+
+type containerUsage struct {
+	Processes       int64                   `yaml:"processes"`
+	CPUUsage        string                  `yaml:"cpu_usage"`
+	MemoryUsage     string                  `yaml:"memory_usage"`
+	MemoryUsagePeak string                  `yaml:"memory_usage_peak"`
+	Disk            map[string]string       `yaml:"disk,omitempty"`
+	Network         map[string]networkUsage `yaml:"network,omitempty"`
+}
+
+type networkUsage struct {
+	Addresses     []string `yaml:"addresses"`
+	BytesReceived string   `yaml:"bytes_received"`
+	BytesSent     string   `yaml:"bytes_sent"`
+}
+
+func newContainerInfo(container *api.Instance, state *api.InstanceState) containerInfo {
+	usage := containerUsage{
+		Processes:       state.Processes,
+		CPUUsage:        time.Duration(state.CPU.Usage).Round(time.Millisecond).String(),
+		MemoryUsage:     units.GetByteSizeStringIEC(state.Memory.Usage, 2),
+		MemoryUsagePeak: units.GetByteSizeStringIEC(state.Memory.UsagePeak, 2),
+	}
+	for name, disk := range state.Disk {
+		if usage.Disk == nil {
+			usage.Disk = make(map[string]string)
+		}
+		usage.Disk[name] = units.GetByteSizeStringIEC(disk.Usage, 2)
+	}
+	for name, network := range state.Network {
+		if network.Type == "loopback" {
+			continue
+		}
+		if usage.Network == nil {
+			usage.Network = make(map[string]networkUsage)
+		}
+		var addresses []string
+		for _, addr := range network.Addresses {
+			addresses = append(addresses, addr.Address+"/"+addr.Netmask)
+		}
+		usage.Network[name] = networkUsage{
+			Addresses:     addresses,
+			BytesReceived: units.GetByteSizeStringIEC(network.Counters.BytesReceived, 2),
+			BytesSent:     units.GetByteSizeStringIEC(network.Counters.BytesSent, 2),
+		}
+	}
+	return containerInfo{Instance: container, State: usage}
+}
+
+type instanceRow struct {
+	Name         string `json:"name" yaml:"name"`
+	FriendlyName string `json:"friendly_name" yaml:"friendly_name"`
+	State        string `json:"state" yaml:"state"`
+	SSHPort      int    `json:"ssh_port" yaml:"ssh_port"`
+	Team         string `json:"team,omitempty" yaml:"team,omitempty"`
+}
+
+func newInstanceRow(container api.Instance) instanceRow {
+	team, _ := common.OwnerTeam(container.Config["user.username"])
+	return instanceRow{
+		Name:         container.Name,
+		FriendlyName: container.Config["user.friendlyname"],
+		State:        container.Status,
+		SSHPort:      lxc.SSHPort(container),
+		Team:         team,
+	}
+}
+
+var instanceColumns = []Column[instanceRow]{
+	{Name: "name", Header: "Name", Value: func(r instanceRow) string { return r.Name }},
+	{Name: "friendly_name", Header: "Friendly Name", Value: func(r instanceRow) string { return r.FriendlyName }},
+	{Name: "state", Header: "State", Value: func(r instanceRow) string { return r.State }},
+	{Name: "ssh_port", Header: "SSH Port", Value: func(r instanceRow) string { return strconv.Itoa(r.SSHPort) }, Table: func(r instanceRow) string {
+		if r.SSHPort == 0 {
+			return "N/A"
+		}
+		return strconv.Itoa(r.SSHPort)
+	}},
+	{Name: "team", Header: "Team", Value: func(r instanceRow) string { return r.Team }},
+}
+
+type sharedInstanceRow struct {
+	instanceRow
+	Owner string `json:"owner" yaml:"owner"`
+	Role  string `json:"role" yaml:"role"`
+}
+
+var sharedInstanceColumns = append(adaptColumns(instanceColumns, func(r sharedInstanceRow) instanceRow { return r.instanceRow }),
+	Column[sharedInstanceRow]{Name: "owner", Header: "Owner", Value: func(r sharedInstanceRow) string { return r.Owner }},
+	Column[sharedInstanceRow]{Name: "role", Header: "Role", Value: func(r sharedInstanceRow) string { return r.Role }},
+)
+
+var shareColumns = []Column[common.DBShare]{
+	{Name: "username", Header: "Username", Value: func(s common.DBShare) string { return s.Username }},
+	{Name: "role", Header: "Role", Value: func(s common.DBShare) string { return s.Role }},
+	{Name: "created_by", Header: "Created By", Value: func(s common.DBShare) string { return s.CreatedBy }},
+	timeColumn("created_at", "Created At", "", func(s common.DBShare) *time.Time { return &s.CreatedAt }),
+}
+
+type imageRow struct {
+	Fingerprint string `json:"fingerprint" yaml:"fingerprint"`
+	Description string `json:"description" yaml:"description"`
+	Type        string `json:"type" yaml:"type"`
+	Size        int64  `json:"size" yaml:"size"`
+}
+
+var imageColumns = []Column[imageRow]{
+	{Name: "fingerprint", Header: "Fingerprint", Value: func(r imageRow) string { return r.Fingerprint }, Table: func(r imageRow) string { return r.Fingerprint[:16] }},
+	{Name: "description", Header: "Description", Value: func(r imageRow) string { return r.Description }},
+	{Name: "type", Header: "Type", Value: func(r imageRow) string { return r.Type }},
+	{Name: "size", Header: "Size", Value: func(r imageRow) string { return strconv.FormatInt(r.Size, 10) }, Table: func(r imageRow) string { return fmt.Sprintf("%dMB", r.Size/1024/1024) }},
+}
+
 type lxcCmd struct {
 	cmd cobra.Command
 	ctx *CommandContext
 }
 
+func (command *lxcCmd) Meta() CommandMeta {
+	return cobraMeta(&command.cmd)
+}
+
 func (command *lxcCmd) Exec(ctx *CommandContext, args []string) error {
 	command.cmd.SetArgs(args[1:])
 	command.cmd.SetIn(ctx)
@@ -27,166 +155,300 @@ func (command *lxcCmd) Exec(ctx *CommandContext, args []string) error {
 	return command.cmd.Execute()
 }
 
+func (command *lxcCmd) Complete(ctx *CommandContext, args []string, toComplete string) []string {
+	command.ctx = ctx
+	return completeCobra(&command.cmd, args, toComplete)
+}
+
 func NewLxcCmd() Command {
 	command := &lxcCmd{
 		cmd: cobra.Command{
-			Use: "lxc",
+			Use:   "lxc",
+			Short: "Manage your containers",
 		},
 		ctx: nil,
 	}
-	command.cmd.AddCommand(&cobra.Command{
-		Use: "list",
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List your containers and those of your teams",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := command.ctx
-			containers, err := common.Client.ListContainers(ctx.User())
+			shared, err := cmd.Flags().GetBool("shared")
 			if err != nil {
 				return err
 			}
-			table := tablewriter.NewWriter(cmd.OutOrStdout())
-			table.SetRowLine(true)
-			table.SetAlignment(tablewriter.ALIGN_LEFT)
-			table.SetHeader([]string{"Name", "Friendly Name", "State", "SSH Port"})
-			for _, container := range containers {
-				port := common.Client.SSHPort(container.Name)
-				name := container.Config["user.friendlyname"]
-				portStr := ""
-				if port == 0 {
-					portStr = "N/A"
-				} else {
-					portStr = strconv.Itoa(port)
+			if shared {
+				containers, err := common.ListSharedContainers(ctx.Context(), ctx.User())
+				if err != nil {
+					return err
 				}
-				table.Append([]string{container.Name, name, container.Status, portStr})
+				rows := make([]sharedInstanceRow, 0, len(containers))
+				for _, share := range containers {
+					rows = append(rows, sharedInstanceRow{
+						instanceRow: newInstanceRow(share.Container),
+						Owner:       share.Container.Config["user.username"],
+						Role:        share.Role,
+					})
+				}
+				return PrintList(cmd, rows, sharedInstanceColumns)
 			}
-			table.Render()
-			return nil
+			containers, err := common.ListAccessibleContainers(ctx.Context(), ctx.User())
+			if err != nil {
+				return err
+			}
+			rows := make([]instanceRow, 0, len(containers))
+			for _, container := range containers {
+				rows = append(rows, newInstanceRow(container))
+			}
+			return PrintList(cmd, rows, instanceColumns)
 		},
-	})
+	}
+	listCmd.Flags().Bool("shared", false, "List the containers other users shared with you instead")
+	AddFormatFlags(listCmd)
+	command.cmd.AddCommand(listCmd)
 	command.cmd.AddCommand(&cobra.Command{
-		Use:  "start <name>",
-		Args: ExactArgs(1),
+		Use:   "start <name>",
+		Short: "Start a container",
+		Args:  ExactArgs(1),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			return completeContainers(command.ctx)
+		}),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := command.ctx
-			err := common.Client.StartContainer(ctx.User(), args[0])
-			return err
+			container, err := common.GetAccessibleContainer(ctx.Context(), ctx.User(), args[0], common.ShareOperate)
+			if err != nil {
+				return err
+			}
+			return common.Client.StartContainer(ctx.Context(), container.Config["user.username"], container.Name)
 		},
 	})
 	command.cmd.AddCommand(&cobra.Command{
-		Use:  "stop <name>",
-		Args: ExactArgs(1),
+		Use:   "stop <name>",
+		Short: "Stop a container",
+		Args:  ExactArgs(1),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			return completeContainers(command.ctx)
+		}),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := command.ctx
-			err := common.Client.StopContainer(ctx.User(), args[0])
-			return err
+			container, err := common.GetAccessibleContainer(ctx.Context(), ctx.User(), args[0], common.ShareOperate)
+			if err != nil {
+				return err
+			}
+			return common.Client.StopContainer(ctx.Context(), container.Config["user.username"], container.Name)
 		},
 	})
 	command.cmd.AddCommand(&cobra.Command{
-		Use:  "delete <name>",
-		Args: ExactArgs(1),
+		Use:   "delete <name>",
+		Short: "Delete a container",
+		Args:  ExactArgs(1),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			return completeContainers(command.ctx)
+		}),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := command.ctx
-			err := common.Client.DeleteContainer(ctx.User(), args[0])
-			return err
+			return common.DeleteContainer(ctx.Context(), ctx.User(), args[0])
 		},
 	})
 	command.cmd.AddCommand(&cobra.Command{
-		Use:  "info <name>",
-		Args: ExactArgs(1),
+		Use:   "info <name>",
+		Short: "Show a container and its resource usage",
+		Args:  ExactArgs(1),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			return completeContainers(command.ctx)
+		}),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := command.ctx
-			container, err := common.Client.GetContainer(ctx.User(), args[0])
+			container, err := common.GetAccessibleContainer(ctx.Context(), ctx.User(), args[0], common.ShareView)
 			if err != nil {
 				return err
 			}
-			yaml.NewEncoder(cmd.OutOrStdout()).Encode(container)
-			return nil
+			state, err := common.Client.GetContainerState(ctx.Context(), container.Config["user.username"], container.Name)
+			if err != nil {
+				return err
+			}
+			return yaml.NewEncoder(cmd.OutOrStdout()).Encode(newContainerInfo(container, state))
 		},
 	})
-	createCmd := &cobra.Command{
-		Use:  "create <friendly name>",
-		Args: ExactArgs(1),
+	command.cmd.AddCommand(&cobra.Command{
+		Use:   "events",
+		Short: "Watch lifecycle events of your containers and those of your teams",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := command.ctx
-			containers, err := common.Client.ListContainers(ctx.User())
+			owners, err := common.ContainerOwners(ctx.User())
 			if err != nil {
 				return err
 			}
-			user, err := common.GetUser(ctx.User())
-			if err != nil {
-				return err
-			}
-			if len(containers) >= user.MaxInstanceCount {
-				return errors.New("max instance count reached")
+			events := make(chan lxc.Event, 64)
+			unsubscribe := common.Client.Subscribe(func(event lxc.Event) {
+				if !slices.Contains(owners, event.Username) {
+					return
+				}
+				select {
+				case events <- event:
+				default:
+				}
+			})
+			defer unsubscribe()
+			keyPressed, stop := ctx.keyPress()
+			defer stop()
+			fmt.Fprintln(cmd.OutOrStdout(), "Watching instance events, press any key to stop...")
+			for {
+				select {
+				case event := <-events:
+					fmt.Fprintf(cmd.OutOrStdout(), "%s %-16s %s (%s) [%s]\n",
+						event.Timestamp.Local().Format(time.DateTime), event.Type, event.FriendlyName, event.Instance, event.Source)
+				case <-keyPressed:
+					return nil
+				case <-ctx.Context().Done():
+					return nil
+				}
 			}
+		},
+	})
+	command.cmd.AddCommand(&cobra.Command{
+		Use:   "top",
+		Short: "Show live resource usage of your containers, those of your teams and those shared with you",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runTop(command.ctx)
+		},
+	})
+	createCmd := &cobra.Command{
+		Use:   "create <friendly name>",
+		Short: "Create a container",
+		Args:  ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := command.ctx
 			progress := common.NewProgressRenderer(ctx)
 			image, err := cmd.Flags().GetString("fingerprint")
 			if err != nil {
 				return err
 			}
-			op, err := common.Client.CreateContainer(ctx.User(), args[0], image)
+			team, err := cmd.Flags().GetString("team")
+			if err != nil {
+				return err
+			}
+			var op lxd.Operation
+			if team != "" {
+				op, err = common.CreateTeamContainer(ctx.Context(), ctx.User(), team, args[0], image)
+			} else {
+				op, err = common.CreateContainer(ctx.Context(), ctx.User(), args[0], image)
+			}
 			if err != nil {
 				return err
 			}
 			op.AddHandler(progress.UpdateOp)
-			err = op.Wait()
+			err = lxc.Wait(ctx.Context(), op)
 			return err
 		},
 	}
-	createCmd.Flags().String("fingerprint", common.Client.DefaultImage(), "image fingerprint")
+	createCmd.Flags().String("fingerprint", "", "image fingerprint (defaults to the panel's default image)")
+	createCmd.Flags().String("team", "", "create the container for a team you are a member of, counting against the team's quota")
 	command.cmd.AddCommand(createCmd)
 	command.cmd.AddCommand(&cobra.Command{
-		Use:  "shell <name>",
-		Args: ExactArgs(1),
+		Use:   "shell <name>",
+		Short: "Open a shell in a container",
+		Args:  ExactArgs(1),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			return completeContainers(command.ctx)
+		}),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := command.ctx
-			container, err := common.Client.GetContainer(ctx.User(), args[0])
+			container, err := common.GetAccessibleContainer(ctx.Context(), ctx.User(), args[0], common.ShareShell)
 			if err != nil {
 				return err
 			}
-			ch := make(chan api.InstanceExecControl)
-
-			id := ctx.OnWindowChange(func(window ssh.Window) {
-				ch <- api.InstanceExecControl{
-					Command: "window-resize",
-					Args: map[string]string{
-						"width":  strconv.Itoa(window.Width),
-						"height": strconv.Itoa(window.Height),
-					},
+			return openShell(ctx, cmd, container)
+		},
+	})
+	shareCmd := &cobra.Command{
+		Use:   "share <name> [username]",
+		Short: "Share a container with another user, or list who it is shared with",
+		Long:  "Share a container with another user. The view role lets them list and inspect it, operate also lets them start and stop it and shell also lets them open a shell in it. Sharing it again with the same user changes their role. Without a username, list who the container is shared with.",
+		Args:  cobra.RangeArgs(1, 2),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			return completeContainers(command.ctx)
+		}),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := command.ctx
+			if len(args) == 1 {
+				shares, err := common.ListContainerShares(ctx.Context(), ctx.User(), args[0])
+				if err != nil {
+					return err
 				}
-			})
-			width, height := ctx.WindowSize()
-			err = common.Client.StartShell(container.Name, cmd.InOrStdin(), cmd.OutOrStdout(), width, height, ch)
+				return PrintList(cmd, shares, shareColumns)
+			}
+			role, err := cmd.Flags().GetString("role")
 			if err != nil {
 				return err
 			}
-			ctx.SendEOF()
-			ctx.RemoveWindowChangeHandler(id)
-			return nil
+			return common.ShareContainer(ctx.Context(), ctx.User(), args[0], args[1], role)
 		},
-	})
+	}
+	shareCmd.Flags().String("role", common.ShareView, "Access granted: view, operate or shell")
+	AddFormatFlags(shareCmd)
+	command.cmd.AddCommand(shareCmd)
 	command.cmd.AddCommand(&cobra.Command{
-		Use: "images",
+		Use:   "unshare <name> <username>",
+		Short: "Stop sharing a container with a user",
+		Args:  ExactArgs(2),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			return completeContainers(command.ctx)
+		}),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := command.ctx
+			err := common.UnshareContainer(ctx.Context(), ctx.User(), args[0], args[1])
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%s is not shared with %s", args[0], args[1])
+			}
+			return err
+		},
+	})
+	imagesCmd := &cobra.Command{
+		Use:   "images",
+		Short: "List available images",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			images, err := common.Client.ListImages()
+			images, err := common.Client.ListImages(command.ctx.Context())
 			if err != nil {
 				return err
 			}
-			table := tablewriter.NewWriter(cmd.OutOrStdout())
-			table.SetRowLine(true)
-			table.SetAlignment(tablewriter.ALIGN_LEFT)
-			table.SetHeader([]string{"Fingerprint", "Description", "Type", "Size"})
+			rows := make([]imageRow, 0, len(images))
 			for _, image := range images {
-				table.Append([]string{
-					image.Fingerprint[:16],
-					image.Properties["description"],
-					image.Type,
-					fmt.Sprintf("%dMB", image.Size/1024/1024),
+				rows = append(rows, imageRow{
+					Fingerprint: image.Fingerprint,
+					Description: image.Properties["description"],
+					Type:        image.Type,
+					Size:        image.Size,
 				})
 			}
-			table.Render()
-			return nil
+			return PrintList(cmd, rows, imageColumns)
 		},
-	})
+	}
+	AddFormatFlags(imagesCmd)
+	command.cmd.AddCommand(imagesCmd)
 	command.cmd.SilenceUsage = true
 	command.cmd.SilenceErrors = true
 	return command
 }
+
+// openShell attaches the session to a shell in container.
+func openShell(ctx *CommandContext, cmd *cobra.Command, container *api.Instance) error {
+	ch := make(chan api.InstanceExecControl, 8)
+
+	removeHandler := ctx.OnWindowChange(func(window ssh.Window) {
+		// Drop resizes the shell is too slow to take, or no longer takes
+		select {
+		case ch <- lxc.WindowResize(window.Width, window.Height):
+		default:
+		}
+	})
+	defer removeHandler()
+	width, height := ctx.WindowSize()
+	ctx.ForwardInterrupts()
+	err := common.Client.StartShell(ctx.Context(), container.Name, cmd.InOrStdin(), cmd.OutOrStdout(), width, height, ch)
+	if err != nil {
+		return err
+	}
+	ctx.SendEOF()
+	return nil
+}
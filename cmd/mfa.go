@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"lxcpanel/common"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"rsc.io/qr"
+)
+
+type mfaCmd struct {
+	cmd cobra.Command
+	ctx *CommandContext
+}
+
+func (command *mfaCmd) Meta() CommandMeta {
+	return cobraMeta(&command.cmd)
+}
+
+func (command *mfaCmd) Exec(ctx *CommandContext, args []string) error {
+	command.cmd.SetArgs(args[1:])
+	command.cmd.SetIn(ctx)
+	command.cmd.SetOut(ctx)
+	command.cmd.SetErr(ctx)
+	command.ctx = ctx
+	return command.cmd.Execute()
+}
+
+func (command *mfaCmd) Complete(ctx *CommandContext, args []string, toComplete string) []string {
+	command.ctx = ctx
+	return completeCobra(&command.cmd, args, toComplete)
+}
+
+// printQR draws text as a QR code using half block characters, so each line
+// holds two rows of modules. Colors are inverted to suit dark terminals.
+func printQR(out io.Writer, text string) error {
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		return err
+	}
+	const quiet = 2
+	black := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= code.Size || y >= code.Size {
+			return false
+		}
+		return code.Black(x, y)
+	}
+	var b strings.Builder
+	for y := -quiet; y < code.Size+quiet; y += 2 {
+		for x := -quiet; x < code.Size+quiet; x++ {
+			switch top, bottom := black(x, y), black(x, y+1); {
+			case top && bottom:
+				b.WriteString(" ")
+			case top:
+				b.WriteString("▄")
+			case bottom:
+				b.WriteString("▀")
+			default:
+				b.WriteString("█")
+			}
+		}
+		b.WriteString("\n")
+	}
+	_, err = io.WriteString(out, b.String())
+	return err
+}
+
+func NewMfaCmd() Command {
+	command := &mfaCmd{
+		cmd: cobra.Command{
+			Use:   "mfa",
+			Short: "Manage two-factor authentication",
+			Long:  "With two-factor authentication enabled, logging in asks for a code from an authenticator app after your SSH key.",
+		},
+		ctx: nil,
+	}
+	command.cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show whether two-factor authentication is enabled",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			enabled, err := common.MFAEnabled(command.ctx.User())
+			if err != nil {
+				return err
+			}
+			if enabled {
+				fmt.Fprintln(cmd.OutOrStdout(), "Two-factor authentication is enabled")
+			} else {
+				fmt.Fprintln(cmd.OutOrStdout(), "Two-factor authentication is disabled")
+			}
+			return nil
+		},
+	})
+	command.cmd.AddCommand(&cobra.Command{
+		Use:   "enroll",
+		Short: "Set up an authenticator app",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			enabled, err := common.MFAEnabled(command.ctx.User())
+			if err != nil {
+				return err
+			}
+			if enabled {
+				return fmt.Errorf("two-factor authentication is already enabled, disable it first")
+			}
+			secret, uri, err := common.EnrollMFA(command.ctx.User())
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintln(out, "Scan this QR code with your authenticator app:")
+			if err = printQR(out, uri); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Or enter the secret %s manually, or open:\n%s\n\n", secret, uri)
+			fmt.Fprintln(out, `Then run "mfa confirm <code>" with the code shown by the app.`)
+			return nil
+		},
+	})
+	command.cmd.AddCommand(&cobra.Command{
+		Use:   "confirm <code>",
+		Short: "Enable two-factor authentication after enrolling",
+		Args:  ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.ConfirmMFA(command.ctx.User(), args[0]); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Two-factor authentication enabled")
+			return nil
+		},
+	})
+	command.cmd.AddCommand(&cobra.Command{
+		Use:   "disable <code>",
+		Short: "Disable two-factor authentication",
+		Args:  ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := common.DisableMFA(command.ctx.User(), args[0]); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Two-factor authentication disabled")
+			return nil
+		},
+	})
+	command.cmd.SilenceUsage = true
+	command.cmd.SilenceErrors = true
+	return command
+}
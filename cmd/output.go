@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Column describes one field of a listing. Name is the stable key used by
+// --columns and as the CSV header, Header is shown in tables.
+type Column[T any] struct {
+	Name   string
+	Header string
+	Value  func(T) string
+	// Table optionally overrides Value for the human readable table, e.g. to
+	// shorten or wrap long values.
+	Table func(T) string
+}
+
+// adaptColumns reuses columns of T for rows of U.
+func adaptColumns[T any, U any](columns []Column[T], get func(U) T) []Column[U] {
+	adapted := make([]Column[U], 0, len(columns))
+	for _, column := range columns {
+		c := Column[U]{
+			Name:   column.Name,
+			Header: column.Header,
+			Value:  func(u U) string { return column.Value(get(u)) },
+		}
+		if column.Table != nil {
+			c.Table = func(u U) string { return column.Table(get(u)) }
+		}
+		adapted = append(adapted, c)
+	}
+	return adapted
+}
+
+// AddFormatFlags adds the --format and --columns flags used by PrintList.
+func AddFormatFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("format", "f", "table", "Output format (table, json, yaml, csv)")
+	cmd.Flags().StringSlice("columns", nil, "Columns to show in table and csv output")
+}
+
+// PrintList writes items in the format selected by the command's flags. JSON
+// and YAML encode the items themselves, so their field names come from the
+// struct tags.
+func PrintList[T any](cmd *cobra.Command, items []T, columns []Column[T]) error {
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	names, err := cmd.Flags().GetStringSlice("columns")
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		var selected []Column[T]
+		for _, name := range names {
+			i := slices.IndexFunc(columns, func(c Column[T]) bool { return c.Name == name })
+			if i < 0 {
+				var available []string
+				for _, c := range columns {
+					available = append(available, c.Name)
+				}
+				return fmt.Errorf("unknown column %q, expected one of: %s", name, strings.Join(available, ", "))
+			}
+			selected = append(selected, columns[i])
+		}
+		columns = selected
+	}
+	if items == nil {
+		items = []T{}
+	}
+
+	out := cmd.OutOrStdout()
+	switch format {
+	case "table":
+		table := tablewriter.NewWriter(out)
+		table.SetRowLine(true)
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		headers := make([]string, len(columns))
+		for i, c := range columns {
+			headers[i] = c.Header
+		}
+		table.SetHeader(headers)
+		for _, item := range items {
+			row := make([]string, len(columns))
+			for i, c := range columns {
+				if c.Table != nil {
+					row[i] = c.Table(item)
+				} else {
+					row[i] = c.Value(item)
+				}
+			}
+			table.Append(row)
+		}
+		table.Render()
+		return nil
+	case "csv":
+		w := csv.NewWriter(out)
+		headers := make([]string, len(columns))
+		for i, c := range columns {
+			headers[i] = c.Name
+		}
+		w.Write(headers)
+		for _, item := range items {
+			row := make([]string, len(columns))
+			for i, c := range columns {
+				row[i] = c.Value(item)
+			}
+			w.Write(row)
+		}
+		w.Flush()
+		return w.Error()
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(items)
+	case "yaml":
+		return yaml.NewEncoder(out).Encode(items)
+	default:
+		return fmt.Errorf("unknown format %q, expected one of: table, json, yaml, csv", format)
+	}
+}
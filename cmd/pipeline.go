@@ -0,0 +1,303 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// Operators recognised by the REPL outside of quotes.
+const (
+	opPipe = "|"
+	opSeq  = ";"
+	opAnd  = "&&"
+)
+
+type token struct {
+	value string
+	op    bool
+}
+
+// tokenize splits line into words and operators, honouring single quotes,
+// double quotes and backslash escapes.
+func tokenize(line string) ([]token, error) {
+	var tokens []token
+	var word strings.Builder
+	inWord := false
+	flush := func() {
+		if inWord {
+			tokens = append(tokens, token{value: word.String()})
+			word.Reset()
+			inWord = false
+		}
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			flush()
+		case c == '|' || c == ';':
+			flush()
+			tokens = append(tokens, token{value: string(c), op: true})
+		case c == '&' && i+1 < len(line) && line[i+1] == '&':
+			flush()
+			tokens = append(tokens, token{value: opAnd, op: true})
+			i++
+		case c == '\\':
+			inWord = true
+			if i+1 < len(line) {
+				i++
+				word.WriteByte(line[i])
+			}
+		case c == '\'':
+			inWord = true
+			end := strings.IndexByte(line[i+1:], c)
+			if end < 0 {
+				return nil, errors.New("unterminated quote")
+			}
+			word.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			inWord = true
+			closed := false
+			for i++; i < len(line); i++ {
+				if line[i] == '"' {
+					closed = true
+					break
+				}
+				if line[i] == '\\' && i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\') {
+					i++
+				}
+				word.WriteByte(line[i])
+			}
+			if !closed {
+				return nil, errors.New("unterminated quote")
+			}
+		default:
+			inWord = true
+			word.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens, nil
+}
+
+// pipeline is a command followed by the filters its output is piped into.
+// cond is the operator separating it from the previous pipeline.
+type pipeline struct {
+	cond   string
+	stages [][]string
+}
+
+func parsePipelines(line string) ([]pipeline, error) {
+	tokens, err := tokenize(line)
+	if err != nil {
+		return nil, err
+	}
+	var pipelines []pipeline
+	current := pipeline{cond: opSeq}
+	var stage []string
+	for _, t := range tokens {
+		if !t.op {
+			stage = append(stage, t.value)
+			continue
+		}
+		if len(stage) == 0 {
+			if t.value == opSeq && len(current.stages) == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("syntax error near %q", t.value)
+		}
+		current.stages = append(current.stages, stage)
+		stage = nil
+		if t.value != opPipe {
+			pipelines = append(pipelines, current)
+			current = pipeline{cond: t.value}
+		}
+	}
+	if len(stage) > 0 {
+		current.stages = append(current.stages, stage)
+	} else if len(current.stages) > 0 {
+		return nil, errors.New("syntax error: missing command after |")
+	} else if current.cond == opAnd {
+		return nil, errors.New("syntax error: missing command after &&")
+	}
+	if len(current.stages) > 0 {
+		pipelines = append(pipelines, current)
+	}
+	return pipelines, nil
+}
+
+// filter transforms the output of the previous pipeline stage.
+type filter func(args []string, input []byte) ([]byte, error)
+
+var filters = map[string]filter{
+	"grep": grepFilter,
+	"head": headFilter,
+	"tail": tailFilter,
+	"wc":   wcFilter,
+	"sort": sortFilter,
+}
+
+// filterFlags returns a flag set for filter name which, unlike cobra
+// commands, prints its errors and usage through the returned error only.
+func filterFlags(name string) *pflag.FlagSet {
+	flags := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	return flags
+}
+
+func splitLines(input []byte) []string {
+	text := strings.TrimSuffix(string(input), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+func joinLines(lines []string) []byte {
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func grepFilter(args []string, input []byte) ([]byte, error) {
+	flags := filterFlags("grep")
+	invert := flags.BoolP("invert-match", "v", false, "")
+	ignoreCase := flags.BoolP("ignore-case", "i", false, "")
+	count := flags.BoolP("count", "c", false, "")
+	if err := flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("grep: %w", err)
+	}
+	if flags.NArg() != 1 {
+		return nil, errors.New("usage: grep [-v] [-i] [-c] <pattern>")
+	}
+	pattern := flags.Arg(0)
+	if *ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("grep: %w", err)
+	}
+	var matched []string
+	for _, line := range splitLines(input) {
+		if re.MatchString(line) != *invert {
+			matched = append(matched, line)
+		}
+	}
+	if *count {
+		return []byte(strconv.Itoa(len(matched)) + "\n"), nil
+	}
+	return joinLines(matched), nil
+}
+
+// lineCount parses the -n flag of head and tail, also accepting the short
+// form -N.
+func lineCount(name string, args []string) (int, error) {
+	if len(args) == 1 && len(args[0]) > 1 && args[0][0] == '-' {
+		if n, err := strconv.Atoi(args[0][1:]); err == nil {
+			args = []string{"-n", strconv.Itoa(n)}
+		}
+	}
+	flags := filterFlags(name)
+	n := flags.IntP("lines", "n", 10, "")
+	if err := flags.Parse(args); err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	if flags.NArg() != 0 || *n < 0 {
+		return 0, fmt.Errorf("usage: %s [-n lines]", name)
+	}
+	return *n, nil
+}
+
+func headFilter(args []string, input []byte) ([]byte, error) {
+	n, err := lineCount("head", args)
+	if err != nil {
+		return nil, err
+	}
+	lines := splitLines(input)
+	return joinLines(lines[:min(n, len(lines))]), nil
+}
+
+func tailFilter(args []string, input []byte) ([]byte, error) {
+	n, err := lineCount("tail", args)
+	if err != nil {
+		return nil, err
+	}
+	lines := splitLines(input)
+	return joinLines(lines[max(0, len(lines)-n):]), nil
+}
+
+func wcFilter(args []string, input []byte) ([]byte, error) {
+	flags := filterFlags("wc")
+	lines := flags.BoolP("lines", "l", false, "")
+	words := flags.BoolP("words", "w", false, "")
+	chars := flags.BoolP("bytes", "c", false, "")
+	if err := flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("wc: %w", err)
+	}
+	if flags.NArg() != 0 {
+		return nil, errors.New("usage: wc [-l] [-w] [-c]")
+	}
+	if !*lines && !*words && !*chars {
+		*lines, *words, *chars = true, true, true
+	}
+	var counts []string
+	if *lines {
+		counts = append(counts, strconv.Itoa(bytes.Count(input, []byte("\n"))))
+	}
+	if *words {
+		counts = append(counts, strconv.Itoa(len(bytes.Fields(input))))
+	}
+	if *chars {
+		counts = append(counts, strconv.Itoa(len(input)))
+	}
+	return []byte(strings.Join(counts, " ") + "\n"), nil
+}
+
+func sortFilter(args []string, input []byte) ([]byte, error) {
+	flags := filterFlags("sort")
+	reverse := flags.BoolP("reverse", "r", false, "")
+	numeric := flags.BoolP("numeric-sort", "n", false, "")
+	unique := flags.BoolP("unique", "u", false, "")
+	if err := flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("sort: %w", err)
+	}
+	if flags.NArg() != 0 {
+		return nil, errors.New("usage: sort [-r] [-n] [-u]")
+	}
+	lines := splitLines(input)
+	compare := strings.Compare
+	if *numeric {
+		compare = func(a, b string) int {
+			x, _ := strconv.ParseFloat(strings.Fields(a + " 0")[0], 64)
+			y, _ := strconv.ParseFloat(strings.Fields(b + " 0")[0], 64)
+			if x != y {
+				if x < y {
+					return -1
+				}
+				return 1
+			}
+			return strings.Compare(a, b)
+		}
+	}
+	slices.SortStableFunc(lines, func(a, b string) int {
+		if *reverse {
+			return compare(b, a)
+		}
+		return compare(a, b)
+	})
+	if *unique {
+		lines = slices.Compact(lines)
+	}
+	return joinLines(lines), nil
+}
@@ -1,18 +1,120 @@
 package cmd
 
 import (
+	"fmt"
 	"lxcpanel/common"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 )
 
+var pubkeyFingerprintColumn = Column[common.DBPubKey]{
+	Name:   "fingerprint",
+	Header: "Fingerprint",
+	Value:  func(k common.DBPubKey) string { return k.Fingerprint },
+	Table:  func(k common.DBPubKey) string { return shortFingerprint(k.Fingerprint) },
+}
+
+var pubkeyCommentColumn = Column[common.DBPubKey]{
+	Name:   "comment",
+	Header: "Comment",
+	Value:  func(k common.DBPubKey) string { return k.Comment },
+}
+
+var pubkeyLabelColumn = Column[common.DBPubKey]{
+	Name:   "label",
+	Header: "Label",
+	Value:  func(k common.DBPubKey) string { return k.Label },
+}
+
+// pubkeyTimeColumns show when a key was added, last used and expires.
+var pubkeyTimeColumns = []Column[common.DBPubKey]{
+	timeColumn("created_at", "Created At", "", func(k common.DBPubKey) *time.Time { return &k.CreatedAt }),
+	timeColumn("last_used_at", "Last Used", "never", func(k common.DBPubKey) *time.Time { return k.LastUsedAt }),
+	timeColumn("expires_at", "Expires At", "never", func(k common.DBPubKey) *time.Time { return k.ExpiresAt }),
+}
+
+// timeColumn shows an optional time, RFC 3339 formatted in csv and as local
+// time in tables where a nil time is shown as unset.
+func timeColumn[T any](name string, header string, unset string, value func(T) *time.Time) Column[T] {
+	return Column[T]{
+		Name:   name,
+		Header: header,
+		Value: func(item T) string {
+			if t := value(item); t != nil {
+				return t.Format(time.RFC3339)
+			}
+			return ""
+		},
+		Table: func(item T) string {
+			if t := value(item); t != nil {
+				return t.Local().Format(time.DateTime)
+			}
+			return unset
+		},
+	}
+}
+
+// parseExpiry parses the --expires flag, either a date, an RFC 3339 time or
+// a duration from now such as 90d or 12h. An empty value never expires.
+func parseExpiry(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			t := time.Now().AddDate(0, 0, n)
+			return &t, nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		t := time.Now().Add(d)
+		return &t, nil
+	}
+	for _, layout := range []string{time.DateOnly, time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid expiry %q, expected a date, an RFC 3339 time or a duration such as 90d", value)
+}
+
+// addPubkeyFlags adds the --label and --expires flags of the add commands.
+func addPubkeyFlags(cmd *cobra.Command) {
+	cmd.Flags().String("label", "", "Label shown in key listings")
+	cmd.Flags().String("expires", "", "Expiry date, RFC 3339 time or duration such as 90d (default never)")
+}
+
+func pubkeyFlags(cmd *cobra.Command) (string, *time.Time, error) {
+	label, err := cmd.Flags().GetString("label")
+	if err != nil {
+		return "", nil, err
+	}
+	expires, err := cmd.Flags().GetString("expires")
+	if err != nil {
+		return "", nil, err
+	}
+	expiresAt, err := parseExpiry(expires)
+	return label, expiresAt, err
+}
+
+// shortFingerprint abbreviates a SHA256 fingerprint for tables and
+// completion, it is still accepted wherever a fingerprint is expected.
+func shortFingerprint(fingerprint string) string {
+	return fingerprint[:min(len(fingerprint), 23)]
+}
+
 type pubkeyCmd struct {
 	cmd cobra.Command
 	ctx *CommandContext
 }
 
+func (command *pubkeyCmd) Meta() CommandMeta {
+	return cobraMeta(&command.cmd)
+}
+
 func (command *pubkeyCmd) Exec(ctx *CommandContext, args []string) error {
 	command.cmd.SetArgs(args[1:])
 	command.cmd.SetIn(ctx)
@@ -22,43 +124,82 @@ func (command *pubkeyCmd) Exec(ctx *CommandContext, args []string) error {
 	return command.cmd.Execute()
 }
 
+func (command *pubkeyCmd) Complete(ctx *CommandContext, args []string, toComplete string) []string {
+	command.ctx = ctx
+	return completeCobra(&command.cmd, args, toComplete)
+}
+
 func NewPubkeyCmd() Command {
 	command := &pubkeyCmd{
 		cmd: cobra.Command{
-			Use: "pubkey",
+			Use:   "pubkey",
+			Short: "Manage your SSH public keys",
 		},
 		ctx: nil,
 	}
-	command.cmd.AddCommand(&cobra.Command{
-		Use: "list",
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List your public keys",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := command.ctx
 			keys, err := common.ListPubkeys(ctx.User())
 			if err != nil {
 				return err
 			}
-			table := tablewriter.NewWriter(cmd.OutOrStdout())
-			table.SetHeader([]string{"Fingerprint", "Public Key"})
-			table.SetRowLine(true)
-			for _, dbkey := range keys {
-				table.Append([]string{dbkey.Fingerprint[:16], common.WordWrap(dbkey.PEM, 48)})
+			columns := []Column[common.DBPubKey]{
+				pubkeyFingerprintColumn,
+				pubkeyLabelColumn,
+				pubkeyCommentColumn,
 			}
-			table.Render()
-			return nil
+			columns = append(columns, pubkeyTimeColumns...)
+			columns = append(columns, Column[common.DBPubKey]{Name: "pubkey", Header: "Public Key", Value: func(k common.DBPubKey) string { return k.PEM }, Table: func(k common.DBPubKey) string { return common.WordWrap(k.PEM, 48) }})
+			return PrintList(cmd, keys, columns)
 		},
-	})
-	command.cmd.AddCommand(&cobra.Command{
-		Use:  "add <public key>",
-		Args: MinimumNArgs(1),
+	}
+	AddFormatFlags(listCmd)
+	command.cmd.AddCommand(listCmd)
+	addCmd := &cobra.Command{
+		Use:   "add <public key>",
+		Short: "Add a public key",
+		Args:  MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := command.ctx
+			label, expiresAt, err := pubkeyFlags(cmd)
+			if err != nil {
+				return err
+			}
 			key := strings.Join(args, " ")
-			return common.AddPubkey(ctx.User(), key)
+			return common.AddPubkey(ctx.User(), key, label, expiresAt)
+		},
+	}
+	addPubkeyFlags(addCmd)
+	command.cmd.AddCommand(addCmd)
+	command.cmd.AddCommand(&cobra.Command{
+		Use:   "label <fingerprint> <label>",
+		Short: "Change the label of a public key",
+		Args:  MinimumNArgs(2),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			keys, err := common.ListPubkeys(command.ctx.User())
+			if err != nil {
+				return nil
+			}
+			return completeFingerprints(keys)
+		}),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return common.SetPubkeyLabel(command.ctx.User(), args[0], strings.Join(args[1:], " "))
 		},
 	})
 	command.cmd.AddCommand(&cobra.Command{
-		Use:  "delete <fingerprint>",
-		Args: ExactArgs(1),
+		Use:   "delete <fingerprint>",
+		Short: "Delete a public key",
+		Args:  ExactArgs(1),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			keys, err := common.ListPubkeys(command.ctx.User())
+			if err != nil {
+				return nil
+			}
+			return completeFingerprints(keys)
+		}),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := command.ctx
 			err := common.DeletePubkey(ctx.User(), args[0])
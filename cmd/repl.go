@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"lxcpanel/metrics"
+	"slices"
+	"strings"
+)
+
+// RunLine executes a REPL line. Commands can be sequenced with ; and &&, and
+// their output piped through the filters (grep, head, tail, wc, sort). Errors
+// are reported on out.
+func RunLine(ctx *CommandContext, commands map[string]Command, line string, out io.Writer) {
+	pipelines, err := parsePipelines(line)
+	if err != nil {
+		fmt.Fprintf(out, "Error: %s\n", err)
+		return
+	}
+	for _, p := range pipelines {
+		// A skipped command keeps the failure for the rest of the && chain
+		if p.cond == opAnd && err != nil {
+			continue
+		}
+		err = runPipeline(ctx, commands, p.stages, out)
+		// Ctrl-C aborts the whole line
+		if ctx.interrupted(err) {
+			return
+		}
+	}
+}
+
+func runPipeline(ctx *CommandContext, commands map[string]Command, stages [][]string, out io.Writer) error {
+	args := stages[0]
+	cmd := commands[args[0]]
+	if cmd == nil {
+		fmt.Fprintf(out, "%s: command not found, type \"help\" for a list of commands\n", args[0])
+		err := fmt.Errorf("%s: command not found", args[0])
+		metrics.CommandsTotal.WithLabelValues("unknown", metrics.Result(err)).Inc()
+		return err
+	}
+	for _, stage := range stages[1:] {
+		if _, ok := filters[stage[0]]; !ok {
+			names := make([]string, 0, len(filters))
+			for name := range filters {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			err := fmt.Errorf("%s: unknown filter, expected one of: %s", stage[0], strings.Join(names, ", "))
+			fmt.Fprintf(out, "Error: %s\n", err)
+			return err
+		}
+	}
+	if len(stages) == 1 {
+		return execCommand(ctx, cmd, args, out)
+	}
+
+	var buf bytes.Buffer
+	restore := ctx.redirect(&buf)
+	err := execCommand(ctx, cmd, args, out)
+	restore()
+	output := buf.Bytes()
+	for _, stage := range stages[1:] {
+		var filterErr error
+		output, filterErr = filters[stage[0]](stage[1:], output)
+		if filterErr != nil {
+			fmt.Fprintf(out, "Error: %s\n", filterErr)
+			return filterErr
+		}
+	}
+	ctx.Write(output)
+	return err
+}
+
+// commandLabel returns the "command" metric label of cmd, run as name. User
+// aliases share one label so users can't create arbitrary label values.
+func commandLabel(cmd Command, name string) string {
+	if isUserAlias(cmd) {
+		return "alias"
+	}
+	return name
+}
+
+func execCommand(ctx *CommandContext, cmd Command, args []string, out io.Writer) error {
+	end := ctx.begin()
+	err := cmd.Exec(ctx, args)
+	end()
+	metrics.CommandsTotal.WithLabelValues(commandLabel(cmd, args[0]), metrics.Result(err)).Inc()
+	if ctx.interrupted(err) {
+		fmt.Fprintln(out, "Interrupted")
+	} else if err != nil {
+		fmt.Fprintf(out, "Error: %s\n", err)
+	}
+	return err
+}
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"lxcpanel/common"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type teamRow struct {
+	Name             string `json:"name" yaml:"name"`
+	Owner            bool   `json:"owner" yaml:"owner"`
+	Instances        int    `json:"instances" yaml:"instances"`
+	MaxInstanceCount int    `json:"max_instance_count" yaml:"max_instance_count"`
+}
+
+var teamColumns = []Column[teamRow]{
+	{Name: "name", Header: "Name", Value: func(r teamRow) string { return r.Name }},
+	{Name: "owner", Header: "Owner", Value: func(r teamRow) string { return strconv.FormatBool(r.Owner) }},
+	{Name: "instances", Header: "Instances", Value: func(r teamRow) string { return strconv.Itoa(r.Instances) }},
+	{Name: "max_instance_count", Header: "Max Instance Count", Value: func(r teamRow) string { return strconv.Itoa(r.MaxInstanceCount) }},
+}
+
+var teamMemberColumns = []Column[common.DBTeamMember]{
+	{Name: "username", Header: "Username", Value: func(m common.DBTeamMember) string { return m.Username }},
+	{Name: "owner", Header: "Owner", Value: func(m common.DBTeamMember) string { return strconv.FormatBool(m.Owner) }},
+	timeColumn("added_at", "Added At", "", func(m common.DBTeamMember) *time.Time { return &m.AddedAt }),
+}
+
+// teamError maps the errors of team member commands to messages naming the
+// team or user.
+func teamError(err error, team string, username string) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, terr := common.GetTeam(team); errors.Is(terr, sql.ErrNoRows) {
+			return fmt.Errorf("team %s not found", team)
+		}
+		return fmt.Errorf("%s is not a member of team %s", username, team)
+	}
+	return err
+}
+
+type teamCmd struct {
+	cmd cobra.Command
+	ctx *CommandContext
+}
+
+func (command *teamCmd) Meta() CommandMeta {
+	return cobraMeta(&command.cmd)
+}
+
+func (command *teamCmd) Exec(ctx *CommandContext, args []string) error {
+	command.cmd.SetArgs(args[1:])
+	command.cmd.SetIn(ctx)
+	command.cmd.SetOut(ctx)
+	command.cmd.SetErr(ctx)
+	command.ctx = ctx
+	return command.cmd.Execute()
+}
+
+func (command *teamCmd) Complete(ctx *CommandContext, args []string, toComplete string) []string {
+	command.ctx = ctx
+	return completeCobra(&command.cmd, args, toComplete)
+}
+
+// requireOwner returns an error unless the user owns team.
+func (command *teamCmd) requireOwner(team string) error {
+	owner, err := common.IsTeamOwner(team, command.ctx.User())
+	if err != nil {
+		return err
+	}
+	if !owner {
+		return fmt.Errorf("only owners of team %s can manage its members", team)
+	}
+	return nil
+}
+
+func NewTeamCmd() Command {
+	command := &teamCmd{
+		cmd: cobra.Command{
+			Use:   "team",
+			Short: "Manage the teams you are a member of",
+			Long:  "Teams own containers shared by their members, created with `lxc create --team <team>` and counting against the team's quota. Members can list, start, stop and open a shell in them, owners can also delete them and add or remove members. Teams are created by admins.",
+		},
+		ctx: nil,
+	}
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List your teams",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := command.ctx
+			teams, err := common.ListUserTeams(ctx.User())
+			if err != nil {
+				return err
+			}
+			rows := make([]teamRow, 0, len(teams))
+			for _, team := range teams {
+				owner, err := common.IsTeamOwner(team.Name, ctx.User())
+				if err != nil {
+					return err
+				}
+				containers, err := common.Client.ListContainers(ctx.Context(), common.TeamOwner(team.Name))
+				if err != nil {
+					return err
+				}
+				rows = append(rows, teamRow{
+					Name:             team.Name,
+					Owner:            owner,
+					Instances:        len(containers),
+					MaxInstanceCount: team.MaxInstanceCount,
+				})
+			}
+			return PrintList(cmd, rows, teamColumns)
+		},
+	}
+	AddFormatFlags(listCmd)
+	command.cmd.AddCommand(listCmd)
+	completeTeams := completeOnce(func(string) []string {
+		return completeUserTeams(command.ctx)
+	})
+	membersCmd := &cobra.Command{
+		Use:               "members <team>",
+		Short:             "List the members of a team",
+		Args:              ExactArgs(1),
+		ValidArgsFunction: completeTeams,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			members, err := common.ListTeamMembers(args[0])
+			if err != nil {
+				return err
+			}
+			isMember := false
+			for _, member := range members {
+				isMember = isMember || member.Username == command.ctx.User()
+			}
+			if !isMember {
+				return fmt.Errorf("you are not a member of team %s", args[0])
+			}
+			return PrintList(cmd, members, teamMemberColumns)
+		},
+	}
+	AddFormatFlags(membersCmd)
+	command.cmd.AddCommand(membersCmd)
+	addCmd := &cobra.Command{
+		Use:               "add <team> <username>",
+		Short:             "Add a member to a team you own",
+		Long:              "Add a member to a team you own. Adding an existing member changes whether they are an owner.",
+		Args:              ExactArgs(2),
+		ValidArgsFunction: completeTeams,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			owner, err := cmd.Flags().GetBool("owner")
+			if err != nil {
+				return err
+			}
+			if err = command.requireOwner(args[0]); err != nil {
+				return err
+			}
+			return teamError(common.AddTeamMember(args[0], args[1], owner), args[0], args[1])
+		},
+	}
+	addCmd.Flags().Bool("owner", false, "Make the member an owner of the team")
+	command.cmd.AddCommand(addCmd)
+	command.cmd.AddCommand(&cobra.Command{
+		Use:               "remove <team> <username>",
+		Short:             "Remove a member from a team you own, or leave a team",
+		Args:              ExactArgs(2),
+		ValidArgsFunction: completeTeams,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if args[1] != command.ctx.User() {
+				if err := command.requireOwner(args[0]); err != nil {
+					return err
+				}
+			}
+			return teamError(common.RemoveTeamMember(args[0], args[1]), args[0], args[1])
+		},
+	})
+	command.cmd.SilenceUsage = true
+	command.cmd.SilenceErrors = true
+	return command
+}
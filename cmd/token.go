@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"lxcpanel/common"
+	"strconv"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+type tokenCmd struct {
+	cmd cobra.Command
+	ctx *CommandContext
+}
+
+func (command *tokenCmd) Meta() CommandMeta {
+	return cobraMeta(&command.cmd)
+}
+
+func (command *tokenCmd) Exec(ctx *CommandContext, args []string) error {
+	command.cmd.SetArgs(args[1:])
+	command.cmd.SetIn(ctx)
+	command.cmd.SetOut(ctx)
+	command.cmd.SetErr(ctx)
+	command.ctx = ctx
+	return command.cmd.Execute()
+}
+
+func (command *tokenCmd) Complete(ctx *CommandContext, args []string, toComplete string) []string {
+	command.ctx = ctx
+	return completeCobra(&command.cmd, args, toComplete)
+}
+
+func NewTokenCmd() Command {
+	command := &tokenCmd{
+		cmd: cobra.Command{
+			Use:   "token",
+			Short: "Manage personal access tokens for the REST API and web terminal",
+		},
+		ctx: nil,
+	}
+	command.cmd.AddCommand(&cobra.Command{
+		Use:   "create <name>",
+		Short: "Create a token",
+		Args:  ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := command.ctx
+			token, err := common.CreateToken(ctx.User(), args[0], ctx.MFAVerified())
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Token created, it will not be shown again:")
+			fmt.Fprintln(cmd.OutOrStdout(), token)
+			return nil
+		},
+	})
+	command.cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List your tokens",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := command.ctx
+			tokens, err := common.ListTokens(ctx.User())
+			if err != nil {
+				return err
+			}
+			table := tablewriter.NewWriter(cmd.OutOrStdout())
+			table.SetHeader([]string{"ID", "Name", "MFA", "Created At"})
+			table.SetRowLine(true)
+			for _, token := range tokens {
+				table.Append([]string{strconv.FormatInt(token.ID, 10), token.Name, strconv.FormatBool(token.MFA), token.CreatedAt.Local().Format(time.DateTime)})
+			}
+			table.Render()
+			return nil
+		},
+	})
+	command.cmd.AddCommand(&cobra.Command{
+		Use:   "revoke <id>",
+		Short: "Revoke a token",
+		Args:  ExactArgs(1),
+		ValidArgsFunction: completeOnce(func(string) []string {
+			tokens, err := common.ListTokens(command.ctx.User())
+			if err != nil {
+				return nil
+			}
+			var ids []string
+			for _, token := range tokens {
+				ids = append(ids, strconv.FormatInt(token.ID, 10))
+			}
+			return ids
+		}),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := command.ctx
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return err
+			}
+			return common.RevokeToken(ctx.User(), id)
+		},
+	})
+	command.cmd.SilenceUsage = true
+	command.cmd.SilenceErrors = true
+	return command
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+	"lxcpanel/common"
+	"strconv"
+	"time"
+
+	"github.com/canonical/lxd/shared/units"
+	"github.com/charmbracelet/ssh"
+	"github.com/olekukonko/tablewriter"
+)
+
+// runTop redraws a table of the containers the caller can access every
+// second until a key is pressed, the command is interrupted or the session is
+// closed.
+func runTop(ctx *CommandContext) error {
+	keyPressed, stop := ctx.keyPress()
+	defer stop()
+
+	resized := make(chan struct{}, 1)
+	removeHandler := ctx.OnWindowChange(func(window ssh.Window) {
+		select {
+		case resized <- struct{}{}:
+		default:
+		}
+	})
+	defer removeHandler()
+
+	// Switch to the alternate screen and hide the cursor
+	fmt.Fprint(ctx, "\033[?1049h\033[?25l")
+	defer fmt.Fprint(ctx, "\033[?25h\033[?1049l")
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lastUsage := make(map[string]int64)
+	lastTime := time.Now()
+	for {
+		containers, err := common.ListAccessibleContainersFull(ctx.Context(), ctx.User())
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		elapsed := now.Sub(lastTime)
+		lastTime = now
+
+		_, height := ctx.WindowSize()
+		fmt.Fprint(ctx, "\033[H\033[2J")
+		fmt.Fprintf(ctx, "%s - %d container(s), press any key to quit\n", now.Format(time.TimeOnly), len(containers))
+		table := tablewriter.NewWriter(ctx)
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.SetHeader([]string{"Name", "Friendly Name", "State", "CPU", "Memory", "Disk", "Processes"})
+		usage := make(map[string]int64)
+		for i, container := range containers {
+			// Leave room for the title, the table borders and the header
+			if height > 0 && i >= height-5 {
+				break
+			}
+			cpu, memory, disk, processes := "-", "-", "-", "-"
+			if container.State != nil {
+				state := container.State
+				usage[container.Name] = state.CPU.Usage
+				if last, ok := lastUsage[container.Name]; ok && elapsed > 0 {
+					percent := float64(state.CPU.Usage-last) / float64(elapsed.Nanoseconds()) * 100
+					cpu = fmt.Sprintf("%.1f%%", percent)
+				}
+				memory = units.GetByteSizeStringIEC(state.Memory.Usage, 1)
+				var diskUsage int64
+				for _, d := range state.Disk {
+					diskUsage += d.Usage
+				}
+				disk = units.GetByteSizeStringIEC(diskUsage, 1)
+				processes = strconv.FormatInt(state.Processes, 10)
+			}
+			table.Append([]string{
+				container.Name,
+				container.Config["user.friendlyname"],
+				container.Status,
+				cpu,
+				memory,
+				disk,
+				processes,
+			})
+		}
+		table.Render()
+		lastUsage = usage
+
+		select {
+		case <-keyPressed:
+			return nil
+		case <-ctx.Context().Done():
+			return nil
+		case <-resized:
+		case <-ticker.C:
+		}
+	}
+}
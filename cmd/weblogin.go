@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"lxcpanel/common"
+	"net"
+	"net/url"
+)
+
+type webloginCmd struct{}
+
+func (cmd *webloginCmd) Meta() CommandMeta {
+	return CommandMeta{
+		Short: "Get a one-time login link for the web terminal",
+	}
+}
+
+func (cmd *webloginCmd) Exec(ctx *CommandContext, args []string) error {
+	if common.WebURL == "" {
+		return errors.New("web terminal is not enabled")
+	}
+	u, err := url.Parse(common.WebURL)
+	if err != nil {
+		return err
+	}
+	// Fall back to the address the user connected to if the web server
+	// listens on a wildcard address.
+	if host := u.Hostname(); host == "" || host == "0.0.0.0" || host == "::" {
+		if port := u.Port(); port != "" {
+			u.Host = net.JoinHostPort(ctx.IP(), port)
+		} else {
+			u.Host = ctx.IP()
+		}
+	}
+	code, err := common.CreateLoginCode(ctx.User())
+	if err != nil {
+		return err
+	}
+	u = u.JoinPath("login", code)
+	fmt.Fprintf(ctx, "Open this link in your browser within %s (it can be used once):\n", common.LoginCodeTTL)
+	fmt.Fprintln(ctx, u.String())
+	return nil
+}
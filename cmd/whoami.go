@@ -1,24 +1,60 @@
 package cmd
 
 import (
-	"fmt"
 	"lxcpanel/common"
 	"strconv"
 
-	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
 )
 
-type whoamiCmd struct{}
+var userColumns = []Column[common.DBUser]{
+	{Name: "username", Header: "Username", Value: func(u common.DBUser) string { return u.Username }},
+	{Name: "admin", Header: "Admin", Value: func(u common.DBUser) string { return strconv.FormatBool(u.Admin) }},
+	{Name: "role", Header: "Role", Value: func(u common.DBUser) string { return u.Role }},
+	{Name: "max_instance_count", Header: "Max Instance Count", Value: func(u common.DBUser) string { return strconv.Itoa(u.MaxInstanceCount) }},
+}
+
+type whoamiCmd struct {
+	cmd cobra.Command
+	ctx *CommandContext
+}
+
+func (command *whoamiCmd) Meta() CommandMeta {
+	return cobraMeta(&command.cmd)
+}
 
-func (cmd *whoamiCmd) Exec(ctx *CommandContext, args []string) error {
-	user, err := common.GetUser(ctx.User())
-	if err != nil {
-		return err
+func (command *whoamiCmd) Exec(ctx *CommandContext, args []string) error {
+	command.cmd.SetArgs(args[1:])
+	command.cmd.SetIn(ctx)
+	command.cmd.SetOut(ctx)
+	command.cmd.SetErr(ctx)
+	command.ctx = ctx
+	return command.cmd.Execute()
+}
+
+func (command *whoamiCmd) Complete(ctx *CommandContext, args []string, toComplete string) []string {
+	command.ctx = ctx
+	return completeCobra(&command.cmd, args, toComplete)
+}
+
+func NewWhoamiCmd() Command {
+	command := &whoamiCmd{
+		cmd: cobra.Command{
+			Use:   "whoami",
+			Short: "Show your account",
+			Args:  ExactArgs(0),
+		},
+		ctx: nil,
+	}
+	command.cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		user, err := common.GetUser(command.ctx.User())
+		if err != nil {
+			return err
+		}
+		return PrintList(cmd, []common.DBUser{user}, userColumns)
 	}
-	table := tablewriter.NewWriter(ctx)
-	table.SetHeader([]string{"Username", "Admin", "Max Instance Count"})
-	table.SetRowLine(true)
-	table.Append([]string{user.Username, fmt.Sprintf("%t", user.Admin), strconv.Itoa(user.MaxInstanceCount)})
-	table.Render()
-	return nil
+	AddFormatFlags(&command.cmd)
+	command.cmd.SilenceUsage = true
+	command.cmd.SilenceErrors = true
+	return command
 }
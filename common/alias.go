@@ -0,0 +1,93 @@
+package common
+
+import "database/sql"
+
+type DBAlias struct {
+	Name      string `json:"name" yaml:"name"`
+	Expansion string `json:"expansion" yaml:"expansion"`
+}
+
+type DBStartupCommand struct {
+	ID   int64  `json:"id" yaml:"id"`
+	Line string `json:"line" yaml:"line"`
+}
+
+// SetAlias creates the alias name for username or replaces its expansion.
+func SetAlias(username string, name string, expansion string) error {
+	_, err := DB.Exec("INSERT INTO aliases (username, name, expansion) VALUES (?, ?, ?) ON CONFLICT (username, name) DO UPDATE SET expansion = excluded.expansion", username, name, expansion)
+	return err
+}
+
+func ListAliases(username string) ([]DBAlias, error) {
+	rows, err := DB.Query("SELECT name, expansion FROM aliases WHERE username = ? ORDER BY name", username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var aliases []DBAlias
+	for rows.Next() {
+		var alias DBAlias
+		if err = rows.Scan(&alias.Name, &alias.Expansion); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, nil
+}
+
+func DeleteAlias(username string, name string) error {
+	res, err := DB.Exec("DELETE FROM aliases WHERE username = ? AND name = ?", username, name)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func AddStartupCommand(username string, line string) (int64, error) {
+	res, err := DB.Exec("INSERT INTO startup_commands (username, line) VALUES (?, ?)", username, line)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// ListStartupCommands returns the commands run when username logs in, in the
+// order they were added.
+func ListStartupCommands(username string) ([]DBStartupCommand, error) {
+	rows, err := DB.Query("SELECT id, line FROM startup_commands WHERE username = ? ORDER BY id", username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var commands []DBStartupCommand
+	for rows.Next() {
+		var command DBStartupCommand
+		if err = rows.Scan(&command.ID, &command.Line); err != nil {
+			return nil, err
+		}
+		commands = append(commands, command)
+	}
+	return commands, nil
+}
+
+func DeleteStartupCommand(username string, id int64) error {
+	res, err := DB.Exec("DELETE FROM startup_commands WHERE username = ? AND id = ?", username, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package common
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Kinds of certificate revocations.
+const (
+	RevokeSerial = "serial"
+	RevokeKeyID  = "key-id"
+)
+
+// defaultMaxInstanceCount is the quota of users provisioned from a
+// certificate, matching the users table default.
+const defaultMaxInstanceCount = 3
+
+var ErrUnknownUser = errors.New("unknown user")
+
+type DBCertAuthority struct {
+	Name        string    `json:"name" yaml:"name"`
+	Fingerprint string    `json:"fingerprint" yaml:"fingerprint"`
+	PEM         string    `json:"pubkey" yaml:"pubkey"`
+	CreatedAt   time.Time `json:"created_at" yaml:"created_at"`
+}
+
+type DBCertRevocation struct {
+	Kind      string    `json:"kind" yaml:"kind"`
+	Value     string    `json:"value" yaml:"value"`
+	CreatedAt time.Time `json:"created_at" yaml:"created_at"`
+}
+
+// AddCertAuthority trusts the user CA pubkey under name.
+func AddCertAuthority(name string, pubkey string) error {
+	normalized, fingerprint, _, err := ParsePubkey(pubkey)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec("INSERT INTO ssh_cas (name, fingerprint, pubkey) VALUES (?, ?, ?)", name, fingerprint, normalized)
+	return err
+}
+
+func ListCertAuthorities() ([]DBCertAuthority, error) {
+	rows, err := DB.Query("SELECT name, fingerprint, pubkey, created_at FROM ssh_cas ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cas []DBCertAuthority
+	for rows.Next() {
+		var ca DBCertAuthority
+		if err = rows.Scan(&ca.Name, &ca.Fingerprint, &ca.PEM, &ca.CreatedAt); err != nil {
+			return nil, err
+		}
+		cas = append(cas, ca)
+	}
+	return cas, nil
+}
+
+func DeleteCertAuthority(name string) error {
+	res, err := DB.Exec("DELETE FROM ssh_cas WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RevokeCertificates rejects every certificate with the given serial or key
+// ID, whichever CA signed it.
+func RevokeCertificates(kind string, value string) error {
+	switch kind {
+	case RevokeSerial:
+		serial, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid serial %q", value)
+		}
+		value = strconv.FormatUint(serial, 10)
+	case RevokeKeyID:
+	default:
+		return fmt.Errorf("unknown revocation kind %q, expected %s or %s", kind, RevokeSerial, RevokeKeyID)
+	}
+	_, err := DB.Exec("INSERT INTO ssh_cert_revocations (kind, value) VALUES (?, ?) ON CONFLICT DO NOTHING", kind, value)
+	return err
+}
+
+func ListCertRevocations() ([]DBCertRevocation, error) {
+	rows, err := DB.Query("SELECT kind, value, created_at FROM ssh_cert_revocations ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var revocations []DBCertRevocation
+	for rows.Next() {
+		var revocation DBCertRevocation
+		if err = rows.Scan(&revocation.Kind, &revocation.Value, &revocation.CreatedAt); err != nil {
+			return nil, err
+		}
+		revocations = append(revocations, revocation)
+	}
+	return revocations, nil
+}
+
+func DeleteCertRevocation(kind string, value string) error {
+	res, err := DB.Exec("DELETE FROM ssh_cert_revocations WHERE kind = ? AND value = ?", kind, value)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func isCertRevoked(cert *gossh.Certificate) (bool, error) {
+	var n int
+	err := DB.QueryRow("SELECT COUNT(*) FROM ssh_cert_revocations WHERE (kind = ? AND value = ?) OR (kind = ? AND value = ?)",
+		RevokeSerial, strconv.FormatUint(cert.Serial, 10), RevokeKeyID, cert.KeyId).Scan(&n)
+	return n > 0, err
+}
+
+func isCertAuthority(key gossh.PublicKey) (bool, error) {
+	var n int
+	err := DB.QueryRow("SELECT COUNT(*) FROM ssh_cas WHERE fingerprint = ?", gossh.FingerprintSHA256(key)).Scan(&n)
+	return n > 0, err
+}
+
+// AuthenticateCertificate checks that cert is a valid user certificate for
+// username, connecting from remote, signed by a trusted CA. The principal
+// must be listed explicitly. ErrUnknownUser is returned for a valid
+// certificate of a user that doesn't exist, see ProvisionUser.
+func AuthenticateCertificate(username string, cert *gossh.Certificate, remote net.Addr) error {
+	if cert.CertType != gossh.UserCert {
+		return errors.New("not a user certificate")
+	}
+	if !ValidUsername(username) {
+		return ErrInvalidUsername
+	}
+	trusted, err := isCertAuthority(cert.SignatureKey)
+	if err != nil {
+		return err
+	}
+	if !trusted {
+		return errors.New("certificate signed by an unknown authority")
+	}
+	if !slices.Contains(cert.ValidPrincipals, username) {
+		return fmt.Errorf("principal %q not in certificate", username)
+	}
+	revoked, err := isCertRevoked(cert)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return fmt.Errorf("certificate %q (serial %d) is revoked", cert.KeyId, cert.Serial)
+	}
+	checker := gossh.CertChecker{
+		SupportedCriticalOptions: []string{"source-address"},
+	}
+	if err = checker.CheckCert(username, cert); err != nil {
+		return err
+	}
+	// The server only enforces source-address for permissions returned by
+	// gossh.CertChecker.Authenticate, which the panel doesn't use
+	if err = checkSourceAddress(remote, cert.CriticalOptions["source-address"]); err != nil {
+		return err
+	}
+	if _, err = GetUser(username); errors.Is(err, sql.ErrNoRows) {
+		return ErrUnknownUser
+	}
+	return err
+}
+
+// ProvisionUser creates username, who logged in with a valid certificate,
+// unless it exists already.
+func ProvisionUser(username string) error {
+	_, err := GetUser(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AddUser(username, RoleUser, defaultMaxInstanceCount)
+	}
+	return err
+}
+
+// checkSourceAddress checks remote against a comma-separated list of
+// addresses and CIDR ranges. An empty list allows any address.
+func checkSourceAddress(remote net.Addr, allowed string) error {
+	if allowed == "" {
+		return nil
+	}
+	tcp, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("cannot check source address of %s", remote)
+	}
+	for _, source := range strings.Split(allowed, ",") {
+		if ip := net.ParseIP(source); ip != nil && ip.Equal(tcp.IP) {
+			return nil
+		}
+		if _, ipNet, err := net.ParseCIDR(source); err == nil && ipNet.Contains(tcp.IP) {
+			return nil
+		}
+	}
+	return fmt.Errorf("source address %s not allowed by certificate", tcp.IP)
+}
@@ -0,0 +1,177 @@
+package common
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// testDB points DB at a fresh database for the duration of the test.
+func testDB(t *testing.T) {
+	t.Helper()
+	InitDB(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(func() { DB.Close() })
+}
+
+func newSigner(t *testing.T) gossh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// newCert returns a certificate for alice signed by ca, valid for an hour,
+// after applying modify.
+func newCert(t *testing.T, ca gossh.Signer, modify func(*gossh.Certificate)) *gossh.Certificate {
+	t.Helper()
+	now := time.Now()
+	cert := &gossh.Certificate{
+		Key:             newSigner(t).PublicKey(),
+		Serial:          42,
+		CertType:        gossh.UserCert,
+		KeyId:           "alice@example.com",
+		ValidPrincipals: []string{"alice"},
+		ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(now.Add(time.Hour).Unix()),
+	}
+	if modify != nil {
+		modify(cert)
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestAuthenticateCertificate(t *testing.T) {
+	testDB(t)
+	ca := newSigner(t)
+	if err := AddCertAuthority("corp", string(gossh.MarshalAuthorizedKey(ca.PublicKey()))); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddUser("alice", RoleUser, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := RevokeCertificates(RevokeSerial, "666"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RevokeCertificates(RevokeKeyID, "stolen"); err != nil {
+		t.Fatal(err)
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 50000}
+
+	tests := []struct {
+		name     string
+		username string
+		cert     *gossh.Certificate
+		wantErr  error
+		ok       bool
+	}{
+		{name: "valid", cert: newCert(t, ca, nil), ok: true},
+		{name: "unknown CA", cert: newCert(t, newSigner(t), nil)},
+		{name: "principal not listed", cert: newCert(t, ca, func(c *gossh.Certificate) {
+			c.ValidPrincipals = []string{"bob"}
+		})},
+		{name: "no principals", cert: newCert(t, ca, func(c *gossh.Certificate) {
+			c.ValidPrincipals = nil
+		})},
+		{name: "host certificate", cert: newCert(t, ca, func(c *gossh.Certificate) {
+			c.CertType = gossh.HostCert
+		})},
+		{name: "expired", cert: newCert(t, ca, func(c *gossh.Certificate) {
+			c.ValidAfter = uint64(time.Now().Add(-2 * time.Hour).Unix())
+			c.ValidBefore = uint64(time.Now().Add(-time.Hour).Unix())
+		})},
+		{name: "not yet valid", cert: newCert(t, ca, func(c *gossh.Certificate) {
+			c.ValidAfter = uint64(time.Now().Add(time.Hour).Unix())
+			c.ValidBefore = uint64(time.Now().Add(2 * time.Hour).Unix())
+		})},
+		{name: "revoked serial", cert: newCert(t, ca, func(c *gossh.Certificate) {
+			c.Serial = 666
+		})},
+		{name: "revoked key ID", cert: newCert(t, ca, func(c *gossh.Certificate) {
+			c.KeyId = "stolen"
+		})},
+		{name: "source address allowed", ok: true, cert: newCert(t, ca, func(c *gossh.Certificate) {
+			c.CriticalOptions = map[string]string{"source-address": "198.51.100.1,192.0.2.0/24"}
+		})},
+		{name: "source address not allowed", cert: newCert(t, ca, func(c *gossh.Certificate) {
+			c.CriticalOptions = map[string]string{"source-address": "198.51.100.0/24"}
+		})},
+		{name: "unknown critical option", cert: newCert(t, ca, func(c *gossh.Certificate) {
+			c.CriticalOptions = map[string]string{"force-command": "true"}
+		})},
+		{name: "unknown user", username: "carol", wantErr: ErrUnknownUser, cert: newCert(t, ca, func(c *gossh.Certificate) {
+			c.ValidPrincipals = []string{"carol"}
+		})},
+		{name: "invalid username", username: "team:ops", wantErr: ErrInvalidUsername, cert: newCert(t, ca, func(c *gossh.Certificate) {
+			c.ValidPrincipals = []string{"team:ops"}
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			username := tt.username
+			if username == "" {
+				username = "alice"
+			}
+			err := AuthenticateCertificate(username, tt.cert, remote)
+			switch {
+			case tt.ok && err != nil:
+				t.Fatalf("AuthenticateCertificate() = %v, want nil", err)
+			case !tt.ok && err == nil:
+				t.Fatal("AuthenticateCertificate() = nil, want an error")
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Fatalf("AuthenticateCertificate() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProvisionUser(t *testing.T) {
+	testDB(t)
+	ca := newSigner(t)
+	if err := AddCertAuthority("corp", string(gossh.MarshalAuthorizedKey(ca.PublicKey()))); err != nil {
+		t.Fatal(err)
+	}
+	cert := newCert(t, ca, nil)
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 50000}
+
+	// Checking a certificate, e.g. when a client only queries whether it
+	// would be accepted, must not create the user
+	if err := AuthenticateCertificate("alice", cert, remote); !errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("AuthenticateCertificate() = %v, want %v", err, ErrUnknownUser)
+	}
+	if _, err := GetUser("alice"); err == nil {
+		t.Fatal("user created while checking the certificate")
+	}
+
+	if err := ProvisionUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	user, err := GetUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != RoleUser || user.MaxInstanceCount != defaultMaxInstanceCount {
+		t.Errorf("provisioned user has role %q and quota %d, want %q and %d", user.Role, user.MaxInstanceCount, RoleUser, defaultMaxInstanceCount)
+	}
+	if err = AuthenticateCertificate("alice", cert, remote); err != nil {
+		t.Fatalf("AuthenticateCertificate() after provisioning = %v", err)
+	}
+	// Provisioning is idempotent, a connection may open several sessions
+	if err = ProvisionUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,9 +1,14 @@
 package common
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
 
 	_ "embed"
 
@@ -14,15 +19,31 @@ import (
 var initSQL string
 
 type DBPubKey struct {
-	Username    string
-	Fingerprint string
-	PEM         string
+	Username    string     `json:"username" yaml:"username"`
+	Fingerprint string     `json:"fingerprint" yaml:"fingerprint"`
+	PEM         string     `json:"pubkey,omitempty" yaml:"pubkey,omitempty"`
+	Comment     string     `json:"comment" yaml:"comment"`
+	Label       string     `json:"label" yaml:"label"`
+	CreatedAt   time.Time  `json:"created_at" yaml:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at" yaml:"last_used_at"`
+	ExpiresAt   *time.Time `json:"expires_at" yaml:"expires_at"`
 }
 
 type DBUser struct {
-	Username         string
-	Admin            bool
-	MaxInstanceCount int
+	Username         string     `json:"username" yaml:"username"`
+	Admin            bool       `json:"admin" yaml:"admin"`
+	Role             string     `json:"role" yaml:"role"`
+	MaxInstanceCount int        `json:"max_instance_count" yaml:"max_instance_count"`
+	SuspendedAt      *time.Time `json:"suspended_at,omitempty" yaml:"suspended_at,omitempty"`
+	SuspendReason    string     `json:"suspend_reason,omitempty" yaml:"suspend_reason,omitempty"`
+}
+
+type DBToken struct {
+	ID        int64     `json:"id" yaml:"id"`
+	Username  string    `json:"username" yaml:"username"`
+	Name      string    `json:"name" yaml:"name"`
+	MFA       bool      `json:"mfa" yaml:"mfa"`
+	CreatedAt time.Time `json:"created_at" yaml:"created_at"`
 }
 
 func InitDB(path string) {
@@ -34,18 +55,40 @@ func InitDB(path string) {
 	if _, err := DB.Exec(initSQL); err != nil {
 		panic(err)
 	}
+	if err := migrate(); err != nil {
+		panic(err)
+	}
+	if err := failInterruptedJobs(); err != nil {
+		panic(err)
+	}
 }
 
-func ListPubkeys(username string) ([]DBPubKey, error) {
-	rows, err := DB.Query("SELECT fingerprint, pubkey FROM pubkeys WHERE username = ?", username)
+// pubkeyFields are the columns scanned by scanPubkey.
+const pubkeyFields = "username, fingerprint, pubkey, comment, label, created_at, last_used_at, expires_at"
+
+func scanPubkey(row interface{ Scan(...any) error }) (DBPubKey, error) {
+	var pubkey DBPubKey
+	var lastUsedAt, expiresAt sql.NullTime
+	err := row.Scan(&pubkey.Username, &pubkey.Fingerprint, &pubkey.PEM, &pubkey.Comment, &pubkey.Label, &pubkey.CreatedAt, &lastUsedAt, &expiresAt)
+	if lastUsedAt.Valid {
+		pubkey.LastUsedAt = &lastUsedAt.Time
+	}
+	if expiresAt.Valid {
+		pubkey.ExpiresAt = &expiresAt.Time
+	}
+	return pubkey, err
+}
+
+func queryPubkeys(query string, args ...any) ([]DBPubKey, error) {
+	rows, err := DB.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var pubkeys []DBPubKey
 	for rows.Next() {
-		var pubkey DBPubKey
-		if err = rows.Scan(&pubkey.Fingerprint, &pubkey.PEM); err != nil {
+		pubkey, err := scanPubkey(rows)
+		if err != nil {
 			return nil, err
 		}
 		pubkeys = append(pubkeys, pubkey)
@@ -53,58 +96,183 @@ func ListPubkeys(username string) ([]DBPubKey, error) {
 	return pubkeys, nil
 }
 
+func ListPubkeys(username string) ([]DBPubKey, error) {
+	return queryPubkeys("SELECT "+pubkeyFields+" FROM pubkeys WHERE username = ?", username)
+}
+
 func ListAllPubkeys() ([]DBPubKey, error) {
-	rows, err := DB.Query("SELECT username, fingerprint FROM pubkeys")
+	return queryPubkeys("SELECT " + pubkeyFields + " FROM pubkeys")
+}
+
+// GetPubkey returns the key of any user whose fingerprint starts with
+// fingerprint, which must identify a single key.
+func GetPubkey(fingerprint string) (DBPubKey, error) {
+	match, err := matchPubkey(DB, "", fingerprint)
 	if err != nil {
-		return nil, err
+		return DBPubKey{}, err
+	}
+	return scanPubkey(DB.QueryRow("SELECT "+pubkeyFields+" FROM pubkeys WHERE fingerprint = ?", match))
+}
+
+// PubkeyValid reports whether username may log in with the key of
+// fingerprint, i.e. whether the key is registered and not expired.
+func PubkeyValid(username, fingerprint string) (bool, error) {
+	var n int
+	err := DB.QueryRow("SELECT COUNT(*) FROM pubkeys WHERE username = ? AND fingerprint = ? AND (expires_at IS NULL OR expires_at > ?)", username, fingerprint, time.Now().UTC()).Scan(&n)
+	return n > 0, err
+}
+
+// UsePubkey records that username logged in with the key of fingerprint.
+func UsePubkey(username, fingerprint string) error {
+	_, err := DB.Exec("UPDATE pubkeys SET last_used_at = ? WHERE username = ? AND fingerprint = ?", time.Now().UTC(), username, fingerprint)
+	return err
+}
+
+// AddPubkey validates pubkey and adds it to username. A key can only be
+// registered to one user. expiresAt may be nil for keys that never expire.
+func AddPubkey(username, pubkey, label string, expiresAt *time.Time) error {
+	normalized, fingerprint, comment, err := ParsePubkey(pubkey)
+	if err != nil {
+		return err
+	}
+	if expiresAt != nil {
+		utc := expiresAt.UTC()
+		expiresAt = &utc
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = insertPubkey(tx, username, normalized, fingerprint, comment, label, expiresAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertPubkey adds an already parsed key unless it is registered already.
+func insertPubkey(tx *sql.Tx, username, normalized, fingerprint, comment, label string, expiresAt *time.Time) error {
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM pubkeys WHERE fingerprint = ?", fingerprint).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrDuplicatePubkey
+	}
+	_, err := tx.Exec("INSERT INTO pubkeys (username, fingerprint, pubkey, comment, label, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		username, fingerprint, normalized, comment, label, time.Now().UTC(), expiresAt)
+	return err
+}
+
+// matchPubkey returns the fingerprint of the single key of username, or of
+// any user if username is empty, whose fingerprint starts with fingerprint.
+func matchPubkey(db interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}, username, fingerprint string) (string, error) {
+	fingerprint = fingerprintPrefix(fingerprint)
+	if fingerprint == "SHA256:" {
+		return "", errors.New("fingerprint is required")
+	}
+	rows, err := db.Query("SELECT fingerprint FROM pubkeys WHERE (? = '' OR username = ?) AND SUBSTR(fingerprint, 1, ?) = ?", username, username, len(fingerprint), fingerprint)
+	if err != nil {
+		return "", err
 	}
 	defer rows.Close()
-	var pubkeys []DBPubKey
+	var matches []string
 	for rows.Next() {
-		var pubkey DBPubKey
-		if err = rows.Scan(&pubkey.Username, &pubkey.Fingerprint); err != nil {
-			return nil, err
+		var match string
+		if err = rows.Scan(&match); err != nil {
+			return "", err
 		}
-		pubkeys = append(pubkeys, pubkey)
+		matches = append(matches, match)
+	}
+	switch len(matches) {
+	case 0:
+		return "", sql.ErrNoRows
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("fingerprint %s is ambiguous", fingerprint)
 	}
-	return pubkeys, nil
 }
 
-func GetPubkey(fingerprint string) (DBPubKey, error) {
-	var pubkey DBPubKey
-	row := DB.QueryRow("SELECT username, pubkey, fingerprint FROM pubkeys WHERE SUBSTR(fingerprint, 1, ?) = ?", len(fingerprint), fingerprint)
-	err := row.Scan(&pubkey.Username, &pubkey.PEM, &pubkey.Fingerprint)
-	return pubkey, err
+// DeletePubkey deletes the key of username whose fingerprint starts with
+// fingerprint, which must identify a single key.
+func DeletePubkey(username, fingerprint string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	match, err := matchPubkey(tx, username, fingerprint)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM pubkeys WHERE username = ? AND fingerprint = ?", username, match); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func AddPubkey(username, pubkey string) error {
-	hash := sha256.Sum256([]byte(pubkey))
-	hexhash := hex.EncodeToString(hash[:])
-	_, err := DB.Exec("INSERT INTO pubkeys (username, fingerprint, pubkey) VALUES (?, ?, ?)", username, hexhash, pubkey)
-	return err
+// SetPubkeyLabel changes the label of the key of username whose fingerprint
+// starts with fingerprint.
+func SetPubkeyLabel(username, fingerprint, label string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	match, err := matchPubkey(tx, username, fingerprint)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("UPDATE pubkeys SET label = ? WHERE username = ? AND fingerprint = ?", label, username, match); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func DeletePubkey(username, fingerprint string) error {
-	_, err := DB.Exec("DELETE FROM pubkeys WHERE username = ? AND SUBSTR(fingerprint, 1, ?) = ?", username, len(fingerprint), fingerprint)
-	return err
+// PrunePubkeys deletes the keys not used since before, counting keys that
+// were never used from their creation. With dryRun the keys are only
+// returned.
+func PrunePubkeys(before time.Time, dryRun bool) ([]DBPubKey, error) {
+	const unused = " FROM pubkeys WHERE COALESCE(last_used_at, created_at) < ?"
+	before = before.UTC()
+	keys, err := queryPubkeys("SELECT "+pubkeyFields+unused, before)
+	if err != nil || dryRun {
+		return keys, err
+	}
+	_, err = DB.Exec("DELETE"+unused, before)
+	return keys, err
 }
 
-func GetUser(username string) (DBUser, error) {
+// userFields are the columns scanned by scanUser.
+const userFields = "users.username, users.admin, users.role, users.max_instance_count, users.suspended_at, users.suspend_reason"
+
+func scanUser(row interface{ Scan(...any) error }) (DBUser, error) {
 	var user DBUser
-	err := DB.QueryRow("SELECT username, admin, max_instance_count FROM users WHERE username = ?", username).Scan(&user.Username, &user.Admin, &user.MaxInstanceCount)
+	var suspendedAt sql.NullTime
+	err := row.Scan(&user.Username, &user.Admin, &user.Role, &user.MaxInstanceCount, &suspendedAt, &user.SuspendReason)
+	if suspendedAt.Valid {
+		user.SuspendedAt = &suspendedAt.Time
+	}
 	return user, err
 }
 
+func GetUser(username string) (DBUser, error) {
+	return scanUser(DB.QueryRow("SELECT "+userFields+" FROM users WHERE username = ?", username))
+}
+
 func ListUsers() ([]DBUser, error) {
-	rows, err := DB.Query("SELECT username, admin, max_instance_count FROM users")
+	rows, err := DB.Query("SELECT " + userFields + " FROM users")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var users []DBUser
 	for rows.Next() {
-		var user DBUser
-		if err = rows.Scan(&user.Username, &user.Admin, &user.MaxInstanceCount); err != nil {
+		user, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
 		users = append(users, user)
@@ -112,14 +280,59 @@ func ListUsers() ([]DBUser, error) {
 	return users, nil
 }
 
-func AddUser(username string, admin bool, maxInstanceCount int) error {
-	_, err := DB.Exec("INSERT INTO users (username, admin, max_instance_count) VALUES (?, ?, ?)", username, admin, maxInstanceCount)
+func AddUser(username string, role string, maxInstanceCount int) error {
+	if !ValidUsername(username) {
+		return ErrInvalidUsername
+	}
+	if _, err := LookupRole(role); err != nil {
+		return err
+	}
+	_, err := DB.Exec("INSERT INTO users (username, admin, role, max_instance_count) VALUES (?, ?, ?, ?)", username, role == RoleAdmin, role, maxInstanceCount)
 	return err
 }
 
-func DeleteUser(username string) error {
-	_, err := DB.Exec("DELETE FROM users WHERE username = ?", username)
-	return err
+// userTables are the tables holding rows of a user, deleted along with it.
+var userTables = []string{"pubkeys", "tokens", "history", "aliases", "startup_commands", "mfa", "team_members", "container_shares"}
+
+// DeleteUser deletes username with its keys, tokens and settings. Users
+// still owning containers are offboarded with OffboardUser instead, and the
+// only owner of a team must hand it over first.
+func DeleteUser(ctx context.Context, username string) error {
+	if err := checkNotSoleTeamOwner(username); err != nil {
+		return err
+	}
+	containers, err := Client.ListContainers(ctx, username)
+	if err != nil {
+		return err
+	}
+	if len(containers) > 0 {
+		return fmt.Errorf("%w: %d container(s)", ErrUserHasContainers, len(containers))
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range userTables {
+		if _, err = tx.Exec("DELETE FROM "+table+" WHERE username = ?", username); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.Exec("DELETE FROM login_failures WHERE kind = ? AND value = ?", BanUser, username); err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM users WHERE username = ?", username)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
 }
 
 func ChangeMaxInstanceCount(username string, maxInstanceCount int) error {
@@ -127,7 +340,88 @@ func ChangeMaxInstanceCount(username string, maxInstanceCount int) error {
 	return err
 }
 
-func ChangeAdmin(username string, admin bool) error {
-	_, err := DB.Exec("UPDATE users SET admin = ? WHERE username = ?", admin, username)
-	return err
+// tokenPrefix makes panel tokens easy to recognise, e.g. in leaked secrets scans.
+const tokenPrefix = "lxcp_"
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// CreateToken generates a new personal access token for username, mfa
+// telling whether it was created in a session that passed MFA. Only the
+// hash is stored, so the returned plaintext cannot be recovered later.
+func CreateToken(username, name string, mfa bool) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := tokenPrefix + hex.EncodeToString(buf)
+	_, err := DB.Exec("INSERT INTO tokens (username, name, hash, mfa) VALUES (?, ?, ?, ?)", username, name, hashToken(token), mfa)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func ListTokens(username string) ([]DBToken, error) {
+	rows, err := DB.Query("SELECT id, username, name, mfa, created_at FROM tokens WHERE username = ?", username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens []DBToken
+	for rows.Next() {
+		var token DBToken
+		if err = rows.Scan(&token.ID, &token.Username, &token.Name, &token.MFA, &token.CreatedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+func RevokeToken(username string, id int64) error {
+	res, err := DB.Exec("DELETE FROM tokens WHERE username = ? AND id = ?", username, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TokenID returns the id of token, so that it can be checked for revocation
+// without keeping the token around.
+func TokenID(token string) (int64, error) {
+	var id int64
+	err := DB.QueryRow("SELECT id FROM tokens WHERE hash = ?", hashToken(token)).Scan(&id)
+	return id, err
+}
+
+// TokenExists reports whether the token with id was not revoked.
+func TokenExists(id int64) (bool, error) {
+	var n int
+	err := DB.QueryRow("SELECT COUNT(*) FROM tokens WHERE id = ?", id).Scan(&n)
+	return n > 0, err
+}
+
+// LookupToken returns the user owning token, unless the user is suspended,
+// and whether the token was created in a session that passed MFA.
+func LookupToken(token string) (DBUser, bool, error) {
+	var username string
+	var mfa bool
+	if err := DB.QueryRow("SELECT username, mfa FROM tokens WHERE hash = ?", hashToken(token)).Scan(&username, &mfa); err != nil {
+		return DBUser{}, false, err
+	}
+	user, err := GetUser(username)
+	if err == nil && user.Suspended() {
+		err = sql.ErrNoRows
+	}
+	return user, mfa, err
 }
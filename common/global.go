@@ -8,4 +8,6 @@ import (
 var (
 	Client *lxc.LXCClient
 	DB     *sql.DB
+	// WebURL is the public base URL of the web terminal, empty if disabled.
+	WebURL string
 )
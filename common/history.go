@@ -0,0 +1,41 @@
+package common
+
+// ListHistory returns the last limit history lines of username, oldest first.
+func ListHistory(username string, limit int) ([]string, error) {
+	rows, err := DB.Query("SELECT line FROM (SELECT id, line FROM history WHERE username = ? ORDER BY id DESC LIMIT ?) ORDER BY id", username, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err = rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// AddHistory appends line to the history of username, keeping only the last
+// limit lines.
+func AddHistory(username string, line string, limit int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec("INSERT INTO history (username, line) VALUES (?, ?)", username, line); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM history WHERE username = ? AND id NOT IN (SELECT id FROM history WHERE username = ? ORDER BY id DESC LIMIT ?)", username, username, limit); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func ClearHistory(username string) error {
+	_, err := DB.Exec("DELETE FROM history WHERE username = ?", username)
+	return err
+}
@@ -3,13 +3,160 @@ PRAGMA foreign_keys = ON;
 CREATE TABLE IF NOT EXISTS users (
     username VARCHAR(50) NOT NULL PRIMARY KEY,
     max_instance_count INTEGER NOT NULL DEFAULT 3,
-    admin BOOLEAN NOT NULL DEFAULT FALSE
+    admin BOOLEAN NOT NULL DEFAULT FALSE,
+    role VARCHAR(20) NOT NULL DEFAULT 'user',
+    suspended_at DATETIME,
+    suspend_reason TEXT NOT NULL DEFAULT ''
 );
 
 CREATE TABLE IF NOT EXISTS pubkeys (
     fingerprint VARCHAR(64) NOT NULL,
     username VARCHAR(50) NOT NULL,
     pubkey TEXT NOT NULL,
+    comment TEXT NOT NULL DEFAULT '',
+    label TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at DATETIME,
+    expires_at DATETIME,
     PRIMARY KEY (fingerprint, username),
     FOREIGN KEY (username) REFERENCES users(username)
 );
+-- The unique index pubkeys_fingerprint is created by migrateUniquePubkeys,
+-- which first removes keys registered by several users.
+
+CREATE TABLE IF NOT EXISTS tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username VARCHAR(50) NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    hash VARCHAR(64) NOT NULL UNIQUE,
+    mfa BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (username) REFERENCES users(username)
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook_id INTEGER NOT NULL,
+    event TEXT NOT NULL,
+    instance TEXT NOT NULL,
+    attempt INTEGER NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (webhook_id) REFERENCES webhooks(id)
+);
+
+CREATE TABLE IF NOT EXISTS history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username VARCHAR(50) NOT NULL,
+    line TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (username) REFERENCES users(username)
+);
+
+CREATE INDEX IF NOT EXISTS history_username ON history (username, id);
+
+CREATE TABLE IF NOT EXISTS aliases (
+    username VARCHAR(50) NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    expansion TEXT NOT NULL,
+    PRIMARY KEY (username, name),
+    FOREIGN KEY (username) REFERENCES users(username)
+);
+
+CREATE TABLE IF NOT EXISTS startup_commands (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username VARCHAR(50) NOT NULL,
+    line TEXT NOT NULL,
+    FOREIGN KEY (username) REFERENCES users(username)
+);
+
+CREATE TABLE IF NOT EXISTS ssh_cas (
+    name VARCHAR(50) NOT NULL PRIMARY KEY,
+    fingerprint VARCHAR(64) NOT NULL UNIQUE,
+    pubkey TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS ssh_cert_revocations (
+    kind VARCHAR(10) NOT NULL,
+    value TEXT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (kind, value)
+);
+
+CREATE TABLE IF NOT EXISTS mfa (
+    username VARCHAR(50) NOT NULL PRIMARY KEY,
+    secret TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    last_step INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (username) REFERENCES users(username)
+);
+
+CREATE TABLE IF NOT EXISTS invites (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    hash VARCHAR(64) NOT NULL UNIQUE,
+    max_instance_count INTEGER NOT NULL DEFAULT 3,
+    created_by VARCHAR(50) NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME,
+    used_by VARCHAR(50),
+    used_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS login_failures (
+    kind VARCHAR(10) NOT NULL,
+    value TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at DATETIME NOT NULL,
+    banned_until DATETIME,
+    PRIMARY KEY (kind, value)
+);
+
+CREATE TABLE IF NOT EXISTS jobs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind VARCHAR(50) NOT NULL,
+    target TEXT NOT NULL,
+    created_by VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    log TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS teams (
+    name VARCHAR(50) NOT NULL PRIMARY KEY,
+    max_instance_count INTEGER NOT NULL DEFAULT 3,
+    created_by VARCHAR(50) NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS team_members (
+    team VARCHAR(50) NOT NULL,
+    username VARCHAR(50) NOT NULL,
+    owner BOOLEAN NOT NULL DEFAULT FALSE,
+    added_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (team, username),
+    FOREIGN KEY (team) REFERENCES teams(name),
+    FOREIGN KEY (username) REFERENCES users(username)
+);
+
+CREATE TABLE IF NOT EXISTS container_shares (
+    instance VARCHAR(50) NOT NULL,
+    username VARCHAR(50) NOT NULL,
+    role VARCHAR(20) NOT NULL,
+    created_by VARCHAR(50) NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (instance, username),
+    FOREIGN KEY (username) REFERENCES users(username)
+);
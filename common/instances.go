@@ -0,0 +1,110 @@
+package common
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	lxd "github.com/canonical/lxd/client"
+	"github.com/canonical/lxd/shared/api"
+)
+
+var ErrMaxInstanceCount = errors.New("max instance count reached")
+
+// CreateContainer creates a container owned by username after checking the
+// user's instance quota. An empty fingerprint selects the default image.
+func CreateContainer(ctx context.Context, username string, friendlyname string, fingerprint string) (lxd.Operation, error) {
+	user, err := GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	return createContainer(ctx, username, user.MaxInstanceCount, friendlyname, fingerprint)
+}
+
+// CreateTeamContainer creates a container owned by team on behalf of its
+// member username, after checking the team's instance quota.
+func CreateTeamContainer(ctx context.Context, username string, team string, friendlyname string, fingerprint string) (lxd.Operation, error) {
+	t, err := GetTeam(team)
+	if err != nil {
+		return nil, err
+	}
+	owners, err := ContainerOwners(username)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(owners, TeamOwner(team)) {
+		return nil, fmt.Errorf("you are not a member of team %s", team)
+	}
+	return createContainer(ctx, TeamOwner(team), t.MaxInstanceCount, friendlyname, fingerprint)
+}
+
+func createContainer(ctx context.Context, owner string, maxInstanceCount int, friendlyname string, fingerprint string) (lxd.Operation, error) {
+	if fingerprint == "" {
+		fingerprint = Client.DefaultImage()
+	}
+	containers, err := Client.ListContainers(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	if len(containers) >= maxInstanceCount {
+		return nil, ErrMaxInstanceCount
+	}
+	return Client.CreateContainer(ctx, owner, friendlyname, fingerprint)
+}
+
+// DeleteContainer deletes a container of username or, if username owns the
+// team, of one of its teams, along with its shares.
+func DeleteContainer(ctx context.Context, username string, name string) error {
+	container, err := getOwnedContainer(ctx, username, name, "delete")
+	if err != nil {
+		return err
+	}
+	return removeContainer(ctx, container)
+}
+
+// DeleteAnyContainer deletes the container called name, whoever owns it,
+// along with its shares.
+func DeleteAnyContainer(ctx context.Context, name string) error {
+	container, err := Client.FindContainer(ctx, name)
+	if err != nil {
+		return err
+	}
+	return removeContainer(ctx, container)
+}
+
+func removeContainer(ctx context.Context, container *api.Instance) error {
+	if err := Client.DeleteContainer(ctx, container.Config["user.username"], container.Name); err != nil {
+		return err
+	}
+	return deleteContainerShares(container.Name)
+}
+
+// TransferContainer makes owner, a username or a TeamOwner, the owner of the
+// container called name. Quotas are not checked. A share of the container
+// with the new owner is removed.
+func TransferContainer(ctx context.Context, name string, owner string) error {
+	container, err := Client.FindContainer(ctx, name)
+	if err != nil {
+		return err
+	}
+	if team, ok := OwnerTeam(owner); ok {
+		if _, err = GetTeam(team); errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("team %s not found", team)
+		}
+	} else {
+		err = checkUserExists(owner)
+	}
+	if err != nil {
+		return err
+	}
+	if container.Config["user.username"] == owner {
+		return fmt.Errorf("%s already owns %s", owner, name)
+	}
+	if err = Client.SetOwner(ctx, container.Name, owner); err != nil {
+		return err
+	}
+	_, err = DB.Exec("DELETE FROM container_shares WHERE instance = ? AND username = ?", container.Name, owner)
+	return err
+}
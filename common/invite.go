@@ -0,0 +1,205 @@
+package common
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// inviteLabel labels keys registered by redeeming an invite.
+const inviteLabel = "invite"
+
+var (
+	ErrInvalidInvite   = errors.New("invalid, used or expired invite code")
+	ErrInvalidUsername = errors.New("usernames must start with a lowercase letter and contain only lowercase letters, digits, - and _, up to 32 characters")
+	ErrUsernameTaken   = errors.New("username is already taken")
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+type DBInvite struct {
+	ID               int64      `json:"id" yaml:"id"`
+	MaxInstanceCount int        `json:"max_instance_count" yaml:"max_instance_count"`
+	CreatedBy        string     `json:"created_by" yaml:"created_by"`
+	CreatedAt        time.Time  `json:"created_at" yaml:"created_at"`
+	ExpiresAt        *time.Time `json:"expires_at" yaml:"expires_at"`
+	UsedBy           string     `json:"used_by" yaml:"used_by"`
+	UsedAt           *time.Time `json:"used_at" yaml:"used_at"`
+}
+
+// Status is "used", "expired" or "open".
+func (invite DBInvite) Status() string {
+	switch {
+	case invite.UsedAt != nil:
+		return "used"
+	case invite.ExpiresAt != nil && !invite.ExpiresAt.After(time.Now()):
+		return "expired"
+	default:
+		return "open"
+	}
+}
+
+// ValidUsername reports whether username is acceptable for a self-registered
+// account.
+func ValidUsername(username string) bool {
+	return usernamePattern.MatchString(username)
+}
+
+// normalizeInviteCode makes codes case-insensitive and ignores the dashes
+// and spaces of the printed groups.
+func normalizeInviteCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+// newInviteCode returns a random code formatted as four groups of four
+// characters, e.g. ABCD-EFGH-IJKL-MNOP.
+func newInviteCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := totpEncoding.EncodeToString(buf)
+	groups := make([]string, 0, 4)
+	for i := 0; i < len(code); i += 4 {
+		groups = append(groups, code[i:i+4])
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// MaxInviteCount is the most invite codes created at once.
+const MaxInviteCount = 100
+
+// CreateInvites generates count single-use invite codes granting accounts
+// with maxInstanceCount instances. Only their hashes are stored, so the
+// returned codes cannot be recovered later. expiresAt may be nil for codes
+// that never expire.
+func CreateInvites(createdBy string, count int, maxInstanceCount int, expiresAt *time.Time) ([]string, error) {
+	if expiresAt != nil {
+		utc := expiresAt.UTC()
+		expiresAt = &utc
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	codes := make([]string, 0, count)
+	for range count {
+		code, err := newInviteCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec("INSERT INTO invites (hash, max_instance_count, created_by, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+			hashToken(normalizeInviteCode(code)), maxInstanceCount, createdBy, time.Now().UTC(), expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, tx.Commit()
+}
+
+func ListInvites() ([]DBInvite, error) {
+	rows, err := DB.Query("SELECT id, max_instance_count, created_by, created_at, expires_at, used_by, used_at FROM invites ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var invites []DBInvite
+	for rows.Next() {
+		var invite DBInvite
+		var expiresAt, usedAt sql.NullTime
+		var usedBy sql.NullString
+		if err = rows.Scan(&invite.ID, &invite.MaxInstanceCount, &invite.CreatedBy, &invite.CreatedAt, &expiresAt, &usedBy, &usedAt); err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid {
+			invite.ExpiresAt = &expiresAt.Time
+		}
+		if usedAt.Valid {
+			invite.UsedAt = &usedAt.Time
+		}
+		invite.UsedBy = usedBy.String
+		invites = append(invites, invite)
+	}
+	return invites, nil
+}
+
+// RevokeInvite deletes the invite id, used or not. Accounts created with it
+// are kept.
+func RevokeInvite(id int64) error {
+	res, err := DB.Exec("DELETE FROM invites WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// HasOpenInvites reports whether any invite can still be redeemed.
+func HasOpenInvites() (bool, error) {
+	var n int
+	err := DB.QueryRow("SELECT COUNT(*) FROM invites WHERE used_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", time.Now().UTC()).Scan(&n)
+	return n > 0, err
+}
+
+// RedeemInvite creates the account username with the quota of the invite
+// code and registers pubkey, an authorized_keys line, to it. The code can't
+// be used again.
+func RedeemInvite(code string, username string, pubkey string) error {
+	if !ValidUsername(username) {
+		return ErrInvalidUsername
+	}
+	normalized, fingerprint, comment, err := ParsePubkey(pubkey)
+	if err != nil {
+		return err
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	var id int64
+	var maxInstanceCount int
+	err = tx.QueryRow("SELECT id, max_instance_count FROM invites WHERE hash = ? AND used_at IS NULL AND (expires_at IS NULL OR expires_at > ?)",
+		hashToken(normalizeInviteCode(code)), now).Scan(&id, &maxInstanceCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidInvite
+	}
+	if err != nil {
+		return err
+	}
+	var n int
+	if err = tx.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrUsernameTaken
+	}
+	if _, err = tx.Exec("INSERT INTO users (username, admin, max_instance_count) VALUES (?, FALSE, ?)", username, maxInstanceCount); err != nil {
+		return err
+	}
+	if err = insertPubkey(tx, username, normalized, fingerprint, comment, inviteLabel, nil); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("UPDATE invites SET used_by = ?, used_at = ? WHERE id = ?", username, now, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
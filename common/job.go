@@ -0,0 +1,93 @@
+package common
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Job statuses.
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// DBJob records a long-running admin operation and its progress log.
+type DBJob struct {
+	ID         int64      `json:"id" yaml:"id"`
+	Kind       string     `json:"kind" yaml:"kind"`
+	Target     string     `json:"target" yaml:"target"`
+	CreatedBy  string     `json:"created_by" yaml:"created_by"`
+	Status     string     `json:"status" yaml:"status"`
+	Log        string     `json:"log" yaml:"log"`
+	Error      string     `json:"error,omitempty" yaml:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at" yaml:"created_at"`
+	FinishedAt *time.Time `json:"finished_at" yaml:"finished_at"`
+}
+
+const jobFields = "id, kind, target, created_by, status, log, error, created_at, finished_at"
+
+func scanJob(row interface{ Scan(...any) error }) (DBJob, error) {
+	var job DBJob
+	var finishedAt sql.NullTime
+	err := row.Scan(&job.ID, &job.Kind, &job.Target, &job.CreatedBy, &job.Status, &job.Log, &job.Error, &job.CreatedAt, &finishedAt)
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return job, err
+}
+
+// StartJob records a running job of kind on target, e.g. a username.
+func StartJob(kind string, target string, createdBy string) (int64, error) {
+	res, err := DB.Exec("INSERT INTO jobs (kind, target, created_by, status, created_at) VALUES (?, ?, ?, ?, ?)",
+		kind, target, createdBy, JobRunning, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// AppendJobLog adds a line to the log of job id.
+func AppendJobLog(id int64, line string) error {
+	_, err := DB.Exec("UPDATE jobs SET log = log || ? WHERE id = ?", line+"\n", id)
+	return err
+}
+
+// FinishJob marks job id as failed with jobErr, or succeeded if it is nil.
+func FinishJob(id int64, jobErr error) error {
+	status, message := JobSucceeded, ""
+	if jobErr != nil {
+		status, message = JobFailed, jobErr.Error()
+	}
+	_, err := DB.Exec("UPDATE jobs SET status = ?, error = ?, finished_at = ? WHERE id = ?", status, message, time.Now().UTC(), id)
+	return err
+}
+
+func ListJobs() ([]DBJob, error) {
+	rows, err := DB.Query("SELECT " + jobFields + " FROM jobs ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []DBJob
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func GetJob(id int64) (DBJob, error) {
+	return scanJob(DB.QueryRow("SELECT "+jobFields+" FROM jobs WHERE id = ?", id))
+}
+
+// failInterruptedJobs marks the jobs left running by a previous process as
+// failed.
+func failInterruptedJobs() error {
+	_, err := DB.Exec("UPDATE jobs SET status = ?, error = ?, finished_at = ? WHERE status = ?",
+		JobFailed, "interrupted by a restart", time.Now().UTC(), JobRunning)
+	return err
+}
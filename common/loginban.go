@@ -0,0 +1,158 @@
+package common
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Kinds of login failure counters.
+const (
+	BanIP   = "ip"
+	BanUser = "user"
+	// BanMFA counts wrong verification codes of a username, which only
+	// users holding a valid key can enter.
+	BanMFA = "mfa"
+)
+
+// BanPolicy decides when repeated login failures lead to a temporary ban.
+type BanPolicy struct {
+	// Threshold is the number of failures leading to the first ban, 0
+	// disables bans.
+	Threshold int
+	// Ban is the length of the first ban, doubled by every further failure
+	// up to MaxBan.
+	Ban    time.Duration
+	MaxBan time.Duration
+	// Window is the time after which failures are forgotten.
+	Window time.Duration
+}
+
+// banDuration returns the ban for the given number of failures, zero below
+// the threshold.
+func (policy BanPolicy) banDuration(failures int) time.Duration {
+	if policy.Threshold <= 0 || failures < policy.Threshold {
+		return 0
+	}
+	ban := policy.Ban
+	for range failures - policy.Threshold {
+		if ban >= policy.MaxBan {
+			break
+		}
+		ban *= 2
+	}
+	return min(ban, policy.MaxBan)
+}
+
+type DBLoginFailure struct {
+	Kind          string     `json:"kind" yaml:"kind"`
+	Value         string     `json:"value" yaml:"value"`
+	Failures      int        `json:"failures" yaml:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at" yaml:"last_failure_at"`
+	BannedUntil   *time.Time `json:"banned_until" yaml:"banned_until"`
+}
+
+// Banned reports whether the ban is still in effect.
+func (failure DBLoginFailure) Banned() bool {
+	return failure.BannedUntil != nil && failure.BannedUntil.After(time.Now())
+}
+
+// RecordLoginFailure counts a failed login for the IP address or username
+// value and bans it according to policy. It returns the end of the ban, nil
+// if value isn't banned.
+func RecordLoginFailure(kind string, value string, policy BanPolicy) (*time.Time, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	var failures int
+	var lastFailureAt time.Time
+	err = tx.QueryRow("SELECT failures, last_failure_at FROM login_failures WHERE kind = ? AND value = ?", kind, value).Scan(&failures, &lastFailureAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if now.Sub(lastFailureAt) > policy.Window {
+		failures = 0
+	}
+	failures++
+	var bannedUntil *time.Time
+	if ban := policy.banDuration(failures); ban > 0 {
+		until := now.Add(ban)
+		bannedUntil = &until
+	}
+	_, err = tx.Exec("INSERT INTO login_failures (kind, value, failures, last_failure_at, banned_until) VALUES (?, ?, ?, ?, ?) "+
+		"ON CONFLICT (kind, value) DO UPDATE SET failures = excluded.failures, last_failure_at = excluded.last_failure_at, banned_until = excluded.banned_until",
+		kind, value, failures, now, bannedUntil)
+	if err != nil {
+		return nil, err
+	}
+	return bannedUntil, tx.Commit()
+}
+
+// LoginBannedUntil returns the end of the ban of the IP address or username
+// value, nil if it isn't banned.
+func LoginBannedUntil(kind string, value string) (*time.Time, error) {
+	var bannedUntil time.Time
+	err := DB.QueryRow("SELECT banned_until FROM login_failures WHERE kind = ? AND value = ? AND banned_until > ?", kind, value, time.Now().UTC()).Scan(&bannedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &bannedUntil, nil
+}
+
+// ResetLoginFailures forgets the failures of value after a successful login.
+func ResetLoginFailures(kind string, value string) error {
+	_, err := DB.Exec("DELETE FROM login_failures WHERE kind = ? AND value = ?", kind, value)
+	return err
+}
+
+// ListLoginFailures returns the failure counters, only those of active bans
+// unless all is set.
+func ListLoginFailures(all bool) ([]DBLoginFailure, error) {
+	query := "SELECT kind, value, failures, last_failure_at, banned_until FROM login_failures"
+	var args []any
+	if !all {
+		query += " WHERE banned_until > ?"
+		args = append(args, time.Now().UTC())
+	}
+	rows, err := DB.Query(query+" ORDER BY last_failure_at DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var failures []DBLoginFailure
+	for rows.Next() {
+		var failure DBLoginFailure
+		var bannedUntil sql.NullTime
+		if err = rows.Scan(&failure.Kind, &failure.Value, &failure.Failures, &failure.LastFailureAt, &bannedUntil); err != nil {
+			return nil, err
+		}
+		if bannedUntil.Valid {
+			failure.BannedUntil = &bannedUntil.Time
+		}
+		failures = append(failures, failure)
+	}
+	return failures, nil
+}
+
+// Unban lifts the ban of the IP address or username value and resets its
+// failures.
+func Unban(kind string, value string) error {
+	res, err := DB.Exec("DELETE FROM login_failures WHERE kind = ? AND value = ?", kind, value)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
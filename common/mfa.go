@@ -0,0 +1,149 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// TOTP parameters as defined by RFC 6238, matching the defaults of common
+// authenticator apps.
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is the number of periods a code is accepted before and after
+	// the current one to allow for clock drift.
+	totpSkew = 1
+	// totpIssuer names the panel in authenticator apps.
+	totpIssuer = "lxcpanel"
+)
+
+var (
+	ErrMFANotEnrolled = errors.New("MFA is not enrolled")
+	ErrInvalidTOTP    = errors.New("invalid verification code")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// EnrollMFA starts MFA enrollment of username with a new secret, replacing
+// any previous one. MFA is only enabled once ConfirmMFA verifies a code. The
+// returned URI can be imported into authenticator apps.
+func EnrollMFA(username string) (secret string, uri string, err error) {
+	buf := make([]byte, 20)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	secret = totpEncoding.EncodeToString(buf)
+	_, err = DB.Exec("INSERT INTO mfa (username, secret) VALUES (?, ?) ON CONFLICT (username) DO UPDATE SET secret = excluded.secret, enabled = FALSE, last_step = 0", username, secret)
+	if err != nil {
+		return "", "", err
+	}
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("period", fmt.Sprint(totpPeriod))
+	query.Set("digits", fmt.Sprint(totpDigits))
+	uri = "otpauth://totp/" + url.PathEscape(totpIssuer+":"+username) + "?" + query.Encode()
+	return secret, uri, nil
+}
+
+// ConfirmMFA enables MFA for username if code is valid for the pending
+// secret.
+func ConfirmMFA(username string, code string) error {
+	if err := verifyTOTP(username, code, false); err != nil {
+		return err
+	}
+	_, err := DB.Exec("UPDATE mfa SET enabled = TRUE WHERE username = ?", username)
+	return err
+}
+
+// DisableMFA removes the MFA secret of username after checking code.
+func DisableMFA(username string, code string) error {
+	if err := verifyTOTP(username, code, true); err != nil {
+		return err
+	}
+	return ResetMFA(username)
+}
+
+// ResetMFA removes the MFA secret of username without a code, for admins
+// helping users who lost their device.
+func ResetMFA(username string) error {
+	_, err := DB.Exec("DELETE FROM mfa WHERE username = ?", username)
+	return err
+}
+
+// MFAEnabled reports whether username has confirmed MFA enrollment.
+func MFAEnabled(username string) (bool, error) {
+	var enabled bool
+	err := DB.QueryRow("SELECT enabled FROM mfa WHERE username = ?", username).Scan(&enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return enabled, err
+}
+
+// VerifyMFA checks a login verification code of username.
+func VerifyMFA(username string, code string) error {
+	return verifyTOTP(username, code, true)
+}
+
+// verifyTOTP checks code against the secret of username. Each code is
+// accepted only once, later codes must belong to a later period.
+func verifyTOTP(username string, code string, enabled bool) error {
+	var secret string
+	var isEnabled bool
+	var lastStep int64
+	err := DB.QueryRow("SELECT secret, enabled, last_step FROM mfa WHERE username = ?", username).Scan(&secret, &isEnabled, &lastStep)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && isEnabled != enabled) {
+		if enabled {
+			return ErrMFANotEnrolled
+		}
+		return errors.New("no pending MFA enrollment, run mfa enroll first")
+	}
+	if err != nil {
+		return err
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= lastStep || !hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			continue
+		}
+		res, err := DB.Exec("UPDATE mfa SET last_step = ? WHERE username = ? AND last_step < ?", step, username, step)
+		if err != nil {
+			return err
+		}
+		// Lost a race with a concurrent login using the same code
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return ErrInvalidTOTP
+		}
+		return nil
+	}
+	return ErrInvalidTOTP
+}
+
+// totpCode computes the code of key for a time step (RFC 4226 HOTP).
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	modulo := uint32(1)
+	for range totpDigits {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulo)
+}
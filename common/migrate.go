@@ -0,0 +1,150 @@
+package common
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrations upgrade databases created by older versions. init.sql always
+// describes the current schema, so each migration must also be a no-op on a
+// freshly created database. The number of applied migrations is kept in
+// PRAGMA user_version.
+var migrations = []func(tx *sql.Tx) error{
+	migrateNormalizePubkeys,
+	migratePubkeyMetadata,
+	migrateTokenMFA,
+	migrateUserSuspension,
+	migrateUserRoles,
+	migrateUniquePubkeys,
+}
+
+func migrate() error {
+	var version int
+	if err := DB.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := DB.Begin()
+		if err != nil {
+			return err
+		}
+		if err = migrations[version](tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+		if _, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migratePubkeyMetadata adds the label and timestamp columns of pubkeys.
+// SQLite can't add a NOT NULL column defaulting to CURRENT_TIMESTAMP, so
+// created_at is added as a nullable column, filled in and the table then
+// rebuilt with the definition of init.sql.
+func migratePubkeyMetadata(tx *sql.Tx) error {
+	columns := []struct{ name, definition string }{
+		{"label", "TEXT NOT NULL DEFAULT ''"},
+		{"created_at", "DATETIME"},
+		{"last_used_at", "DATETIME"},
+		{"expires_at", "DATETIME"},
+	}
+	for _, column := range columns {
+		exists, err := hasColumn(tx, "pubkeys", column.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err = tx.Exec(fmt.Sprintf("ALTER TABLE pubkeys ADD COLUMN %s %s", column.name, column.definition)); err != nil {
+			return err
+		}
+	}
+	var notNull bool
+	if err := tx.QueryRow("SELECT \"notnull\" FROM pragma_table_info('pubkeys') WHERE name = 'created_at'").Scan(&notNull); err != nil || notNull {
+		return err
+	}
+	// The rowids are kept, migrateUniquePubkeys relies on their order.
+	statements := []string{
+		"UPDATE pubkeys SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL",
+		`CREATE TABLE pubkeys_new (
+			fingerprint VARCHAR(64) NOT NULL,
+			username VARCHAR(50) NOT NULL,
+			pubkey TEXT NOT NULL,
+			comment TEXT NOT NULL DEFAULT '',
+			label TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME,
+			expires_at DATETIME,
+			PRIMARY KEY (fingerprint, username),
+			FOREIGN KEY (username) REFERENCES users(username)
+		)`,
+		`INSERT INTO pubkeys_new (rowid, fingerprint, username, pubkey, comment, label, created_at, last_used_at, expires_at)
+			SELECT rowid, fingerprint, username, pubkey, comment, label, created_at, last_used_at, expires_at FROM pubkeys`,
+		"DROP TABLE pubkeys",
+		"ALTER TABLE pubkeys_new RENAME TO pubkeys",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateTokenMFA adds the mfa column of tokens. Existing tokens count as
+// created without MFA.
+func migrateTokenMFA(tx *sql.Tx) error {
+	exists, err := hasColumn(tx, "tokens", "mfa")
+	if err != nil || exists {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE tokens ADD COLUMN mfa BOOLEAN NOT NULL DEFAULT FALSE")
+	return err
+}
+
+// migrateUserSuspension adds the suspension columns of users.
+func migrateUserSuspension(tx *sql.Tx) error {
+	columns := []struct{ name, definition string }{
+		{"suspended_at", "DATETIME"},
+		{"suspend_reason", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, column := range columns {
+		exists, err := hasColumn(tx, "users", column.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err = tx.Exec(fmt.Sprintf("ALTER TABLE users ADD COLUMN %s %s", column.name, column.definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateUserRoles adds the role column of users, making admins admin.
+func migrateUserRoles(tx *sql.Tx) error {
+	exists, err := hasColumn(tx, "users", "role")
+	if err != nil || exists {
+		return err
+	}
+	if _, err = tx.Exec("ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user'"); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE users SET role = ? WHERE admin", RoleAdmin)
+	return err
+}
+
+func hasColumn(tx *sql.Tx, table string, column string) (bool, error) {
+	var n int
+	err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&n)
+	return n > 0, err
+}
@@ -0,0 +1,52 @@
+package common
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestMigratePubkeyMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	// The schema of the first release
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(newSigner(t).PublicKey())))
+	for _, statement := range []string{
+		"CREATE TABLE users (username VARCHAR(50) NOT NULL PRIMARY KEY, max_instance_count INTEGER NOT NULL DEFAULT 3, admin BOOLEAN NOT NULL DEFAULT FALSE)",
+		"CREATE TABLE pubkeys (fingerprint VARCHAR(64) NOT NULL, username VARCHAR(50) NOT NULL, pubkey TEXT NOT NULL, PRIMARY KEY (fingerprint, username), FOREIGN KEY (username) REFERENCES users(username))",
+		"INSERT INTO users (username) VALUES ('alice')",
+		"INSERT INTO pubkeys (fingerprint, username, pubkey) VALUES ('old', 'alice', '" + key + " alice@laptop')",
+	} {
+		if _, err = old.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old.Close()
+
+	InitDB(path)
+	t.Cleanup(func() { DB.Close() })
+
+	var notNull bool
+	if err = DB.QueryRow("SELECT \"notnull\" FROM pragma_table_info('pubkeys') WHERE name = 'created_at'").Scan(&notNull); err != nil {
+		t.Fatal(err)
+	}
+	if !notNull {
+		t.Error("created_at is nullable after the migration")
+	}
+	keys, err := ListPubkeys("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("got %d keys, want 1", len(keys))
+	}
+	if keys[0].CreatedAt.IsZero() || keys[0].Comment != "alice@laptop" {
+		t.Errorf("got created_at %v and comment %q", keys[0].CreatedAt, keys[0].Comment)
+	}
+}
@@ -0,0 +1,207 @@
+package common
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"lxcpanel/lxc"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/charmbracelet/log"
+)
+
+var ErrUserHasContainers = errors.New("user still owns containers, offboard them instead")
+
+// Suspended reports whether the user is suspended.
+func (user DBUser) Suspended() bool {
+	return user.SuspendedAt != nil
+}
+
+// SuspendUser denies username any further login and stops its running
+// containers, returning their names. Suspending a suspended user only
+// updates the reason and stops containers started since.
+func SuspendUser(ctx context.Context, username string, reason string) ([]string, error) {
+	res, err := DB.Exec("UPDATE users SET suspended_at = COALESCE(suspended_at, ?), suspend_reason = ? WHERE username = ?", time.Now().UTC(), reason, username)
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	containers, err := Client.ListContainers(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	var stopped []string
+	var errs []error
+	for _, container := range containers {
+		if container.StatusCode != api.Running {
+			continue
+		}
+		if err = Client.StopContainer(ctx, username, container.Name); err != nil {
+			errs = append(errs, fmt.Errorf("stop %s: %w", container.Name, err))
+			continue
+		}
+		stopped = append(stopped, container.Name)
+	}
+	return stopped, errors.Join(errs...)
+}
+
+// ResumeUser lifts the suspension of username. Its containers are left
+// stopped.
+func ResumeUser(username string) error {
+	res, err := DB.Exec("UPDATE users SET suspended_at = NULL, suspend_reason = '' WHERE username = ?", username)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// OffboardOptions configure OffboardUser.
+type OffboardOptions struct {
+	// BackupDir receives a backup tarball of every container before it is
+	// deleted, no backups are made if empty.
+	BackupDir string
+	// DryRun only lists the steps that would be taken.
+	DryRun bool
+}
+
+type offboardStep struct {
+	description string
+	run         func() error
+}
+
+// OffboardUser removes username and everything it owns: the user is
+// suspended, its containers are backed up if requested and deleted,
+// releasing their SSH ports, then its keys, tokens and the account itself
+// are deleted. The steps are written to out and recorded in a job started
+// by createdBy, whose ID is returned. An offboarding that failed can be run
+// again to finish it.
+func OffboardUser(ctx context.Context, username string, createdBy string, opts OffboardOptions, out io.Writer) (int64, error) {
+	if _, err := GetUser(username); err != nil {
+		return 0, err
+	}
+	if err := checkNotSoleTeamOwner(username); err != nil {
+		return 0, err
+	}
+	containers, err := Client.ListContainers(ctx, username)
+	if err != nil {
+		return 0, err
+	}
+	keys, err := ListPubkeys(username)
+	if err != nil {
+		return 0, err
+	}
+
+	steps := []offboardStep{{
+		description: fmt.Sprintf("Suspend %s and stop their containers", username),
+		run: func() error {
+			_, err := SuspendUser(ctx, username, "offboarded")
+			return err
+		},
+	}}
+	if opts.BackupDir != "" {
+		steps = append(steps, offboardStep{
+			description: fmt.Sprintf("Create backup directory %s", opts.BackupDir),
+			run: func() error {
+				return os.MkdirAll(opts.BackupDir, 0o700)
+			},
+		})
+	}
+	for _, container := range containers {
+		name := container.Name
+		label := name
+		if friendlyname := container.Config["user.friendlyname"]; friendlyname != "" {
+			label = fmt.Sprintf("%s (%s)", friendlyname, name)
+		}
+		if opts.BackupDir != "" {
+			path := filepath.Join(opts.BackupDir, fmt.Sprintf("%s-%s.tar.gz", username, name))
+			steps = append(steps, offboardStep{
+				description: fmt.Sprintf("Back up container %s to %s", label, path),
+				run: func() error {
+					return exportBackup(ctx, username, name, path)
+				},
+			})
+		}
+		description := fmt.Sprintf("Delete container %s", label)
+		port := lxc.SSHPort(container)
+		if port > 0 {
+			description += fmt.Sprintf(", releasing port %d", port)
+		}
+		steps = append(steps, offboardStep{
+			description: description,
+			run: func() error {
+				if err := Client.DeleteContainer(ctx, username, name); err != nil {
+					return err
+				}
+				return deleteContainerShares(name)
+			},
+		})
+	}
+	steps = append(steps, offboardStep{
+		description: fmt.Sprintf("Delete %d key(s), tokens, settings and the account %s", len(keys), username),
+		run: func() error {
+			return DeleteUser(ctx, username)
+		},
+	})
+
+	if opts.DryRun {
+		fmt.Fprintln(out, "Dry run, nothing was changed. Offboarding would:")
+		for _, step := range steps {
+			fmt.Fprintf(out, "  %s\n", step.description)
+		}
+		return 0, nil
+	}
+
+	id, err := StartJob("offboard", username, createdBy)
+	if err != nil {
+		return 0, err
+	}
+	fmt.Fprintf(out, "Started job %d\n", id)
+	for _, step := range steps {
+		fmt.Fprintln(out, step.description)
+		if err = AppendJobLog(id, step.description); err != nil {
+			log.Error("Error updating job log", "job", id, "error", err)
+		}
+		if err = step.run(); err != nil {
+			break
+		}
+	}
+	if ferr := FinishJob(id, err); ferr != nil {
+		log.Error("Error finishing job", "job", id, "error", ferr)
+	}
+	return id, err
+}
+
+// exportBackup writes a backup of the container name to path, removing the
+// file if the export fails.
+func exportBackup(ctx context.Context, username string, name string, path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	err = Client.ExportBackup(ctx, username, name, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
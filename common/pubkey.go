@@ -0,0 +1,160 @@
+package common
+
+import (
+	"crypto/rsa"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// minRSABits is the smallest accepted RSA modulus.
+const minRSABits = 2048
+
+var (
+	ErrInvalidPubkey   = errors.New("invalid public key")
+	ErrDuplicatePubkey = errors.New("public key is already registered")
+)
+
+// ParsePubkey parses a single authorized_keys line, rejecting weak keys. It
+// returns the key in normalized "type base64" form, its SHA256 fingerprint as
+// printed by ssh-keygen -l, and the comment.
+func ParsePubkey(text string) (normalized string, fingerprint string, comment string, err error) {
+	key, comment, err := parsePubkey(text)
+	if err != nil {
+		return "", "", "", err
+	}
+	if err = checkPubkeyStrength(key); err != nil {
+		return "", "", "", err
+	}
+	normalized = strings.TrimSpace(string(gossh.MarshalAuthorizedKey(key)))
+	return normalized, gossh.FingerprintSHA256(key), comment, nil
+}
+
+func parsePubkey(text string) (gossh.PublicKey, string, error) {
+	key, comment, options, rest, err := gossh.ParseAuthorizedKey([]byte(strings.TrimSpace(text)))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %s", ErrInvalidPubkey, err)
+	}
+	if len(options) > 0 {
+		return nil, "", fmt.Errorf("%w: key options are not supported", ErrInvalidPubkey)
+	}
+	if len(strings.TrimSpace(string(rest))) > 0 {
+		return nil, "", fmt.Errorf("%w: expected a single key", ErrInvalidPubkey)
+	}
+	return key, comment, nil
+}
+
+func checkPubkeyStrength(key gossh.PublicKey) error {
+	switch key.Type() {
+	case gossh.KeyAlgoDSA:
+		return fmt.Errorf("%w: DSA keys are not accepted", ErrInvalidPubkey)
+	case gossh.KeyAlgoRSA:
+		rsaKey, ok := key.(gossh.CryptoPublicKey).CryptoPublicKey().(*rsa.PublicKey)
+		if !ok || rsaKey.N.BitLen() < minRSABits {
+			return fmt.Errorf("%w: RSA keys must be at least %d bits", ErrInvalidPubkey, minRSABits)
+		}
+	}
+	return nil
+}
+
+// fingerprintPrefix lets users omit the "SHA256:" prefix of fingerprints.
+func fingerprintPrefix(fingerprint string) string {
+	if !strings.HasPrefix(fingerprint, "SHA256:") {
+		return "SHA256:" + fingerprint
+	}
+	return fingerprint
+}
+
+// migrateNormalizePubkeys re-parses keys stored before validation existed,
+// switching them to normalized keys with SHA256 fingerprints and a separate
+// comment. Unparseable keys, which could never be used to log in, and
+// duplicates are dropped. Weak keys are kept so nobody is locked out, but
+// they can no longer be added.
+func migrateNormalizePubkeys(tx *sql.Tx) error {
+	hasComment, err := hasColumn(tx, "pubkeys", "comment")
+	if err != nil {
+		return err
+	}
+	if !hasComment {
+		if _, err = tx.Exec("ALTER TABLE pubkeys ADD COLUMN comment TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	rows, err := tx.Query("SELECT username, pubkey FROM pubkeys ORDER BY rowid")
+	if err != nil {
+		return err
+	}
+	var keys []DBPubKey
+	for rows.Next() {
+		var key DBPubKey
+		if err = rows.Scan(&key.Username, &key.PEM); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM pubkeys"); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		parsed, comment, err := parsePubkey(key.PEM)
+		if err != nil {
+			log.Warn("Dropping invalid public key", "user", key.Username, "error", err)
+			continue
+		}
+		if err = checkPubkeyStrength(parsed); err != nil {
+			log.Warn("Keeping weak public key", "user", key.Username, "error", err)
+		}
+		normalized := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(parsed)))
+		fingerprint := gossh.FingerprintSHA256(parsed)
+		if seen[key.Username+" "+fingerprint] {
+			continue
+		}
+		seen[key.Username+" "+fingerprint] = true
+		if _, err = tx.Exec("INSERT INTO pubkeys (username, fingerprint, pubkey, comment) VALUES (?, ?, ?, ?)", key.Username, fingerprint, normalized, comment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateUniquePubkeys makes a key identify a single user. Keys registered
+// by several users before that was checked are kept for the user who added
+// them first and removed from the others, who are logged so admins can
+// follow up.
+func migrateUniquePubkeys(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT username, fingerprint FROM pubkeys AS p WHERE EXISTS (SELECT 1 FROM pubkeys AS q WHERE q.fingerprint = p.fingerprint AND q.rowid < p.rowid) ORDER BY rowid")
+	if err != nil {
+		return err
+	}
+	var duplicates []DBPubKey
+	for rows.Next() {
+		var key DBPubKey
+		if err = rows.Scan(&key.Username, &key.Fingerprint); err != nil {
+			rows.Close()
+			return err
+		}
+		duplicates = append(duplicates, key)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, key := range duplicates {
+		log.Warn("Removing public key registered by another user", "user", key.Username, "fingerprint", key.Fingerprint)
+		if _, err = tx.Exec("DELETE FROM pubkeys WHERE username = ? AND fingerprint = ?", key.Username, key.Fingerprint); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("CREATE UNIQUE INDEX IF NOT EXISTS pubkeys_fingerprint ON pubkeys (fingerprint)")
+	return err
+}
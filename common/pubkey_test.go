@@ -0,0 +1,33 @@
+package common
+
+import "testing"
+
+func TestGetPubkey(t *testing.T) {
+	testDB(t)
+	for _, username := range []string{"alice", "bob"} {
+		if err := AddUser(username, RoleUser, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []struct{ username, fingerprint string }{
+		{"alice", "SHA256:abc1"},
+		{"bob", "SHA256:abc2"},
+	} {
+		if _, err := DB.Exec("INSERT INTO pubkeys (username, fingerprint, pubkey) VALUES (?, ?, '')", key.username, key.fingerprint); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key, err := GetPubkey("abc2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Username != "bob" {
+		t.Errorf("got the key of %s, want bob", key.Username)
+	}
+	for _, fingerprint := range []string{"abc", "SHA256:", ""} {
+		if key, err = GetPubkey(fingerprint); err == nil {
+			t.Errorf("GetPubkey(%q) returned the key of %s, want an error", fingerprint, key.Username)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package common
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Permission grants access to a group of admin commands and API routes.
+type Permission string
+
+const (
+	PermUsersView        Permission = "users.view"
+	PermUsersManage      Permission = "users.manage"
+	PermKeysView         Permission = "keys.view"
+	PermKeysManage       Permission = "keys.manage"
+	PermInstancesView    Permission = "instances.view"
+	PermInstancesOperate Permission = "instances.operate"
+	PermInstancesManage  Permission = "instances.manage"
+	PermAuthView         Permission = "auth.view"
+	PermAuthManage       Permission = "auth.manage"
+	PermWebhooksManage   Permission = "webhooks.manage"
+	// PermAll grants every permission, including those of commands that
+	// don't declare one.
+	PermAll Permission = "*"
+)
+
+// Built-in role names.
+const (
+	RoleUser    = "user"
+	RoleTA      = "ta"
+	RoleAuditor = "auditor"
+	RoleAdmin   = "admin"
+)
+
+type Role struct {
+	Name        string       `json:"name" yaml:"name"`
+	Description string       `json:"description" yaml:"description"`
+	Permissions []Permission `json:"permissions" yaml:"permissions"`
+}
+
+// Roles lists the roles that can be assigned to users, from least to most
+// privileged.
+var Roles = []Role{
+	{
+		Name:        RoleUser,
+		Description: "Manage their own containers, keys and settings",
+	},
+	{
+		Name:        RoleTA,
+		Description: "View users and view, start and stop anyone's containers",
+		Permissions: []Permission{PermUsersView, PermInstancesView, PermInstancesOperate},
+	},
+	{
+		Name:        RoleAuditor,
+		Description: "View users, keys, containers and authentication settings",
+		Permissions: []Permission{PermUsersView, PermKeysView, PermInstancesView, PermAuthView},
+	},
+	{
+		Name:        RoleAdmin,
+		Description: "Everything",
+		Permissions: []Permission{PermAll},
+	},
+}
+
+// LookupRole returns the role called name.
+func LookupRole(name string) (Role, error) {
+	for _, role := range Roles {
+		if role.Name == name {
+			return role, nil
+		}
+	}
+	names := make([]string, 0, len(Roles))
+	for _, role := range Roles {
+		names = append(names, role.Name)
+	}
+	return Role{}, fmt.Errorf("unknown role %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// Has reports whether the role grants permission.
+func (role Role) Has(permission Permission) bool {
+	return slices.Contains(role.Permissions, PermAll) || slices.Contains(role.Permissions, permission)
+}
+
+// Elevated reports whether the role grants any permission beyond managing
+// one's own resources.
+func (role Role) Elevated() bool {
+	return len(role.Permissions) > 0
+}
+
+// Can reports whether the user's role grants permission.
+func (user DBUser) Can(permission Permission) bool {
+	role, err := LookupRole(user.Role)
+	return err == nil && role.Has(permission)
+}
+
+// SetRole assigns role to username. The admin flag is kept in line for
+// older clients of the REST API.
+func SetRole(username string, role string) error {
+	if _, err := LookupRole(role); err != nil {
+		return err
+	}
+	res, err := DB.Exec("UPDATE users SET role = ?, admin = ? WHERE username = ?", role, role == RoleAdmin, username)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package common
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"lxcpanel/lxc"
+	"slices"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+)
+
+// Share roles, each granting the ones before it.
+const (
+	ShareView    = "view"
+	ShareOperate = "operate"
+	ShareShell   = "shell"
+)
+
+// ShareRoles lists the share roles from least to most privileged.
+var ShareRoles = []string{ShareView, ShareOperate, ShareShell}
+
+var ErrInvalidShareRole = errors.New("share roles are view, operate and shell")
+
+// ShareGrants reports whether the share role role grants need.
+func ShareGrants(role string, need string) bool {
+	return slices.Index(ShareRoles, role) >= slices.Index(ShareRoles, need)
+}
+
+// DBShare grants a user other than the owner access to a single container.
+type DBShare struct {
+	Instance  string    `json:"instance" yaml:"instance"`
+	Username  string    `json:"username" yaml:"username"`
+	Role      string    `json:"role" yaml:"role"`
+	CreatedBy string    `json:"created_by" yaml:"created_by"`
+	CreatedAt time.Time `json:"created_at" yaml:"created_at"`
+}
+
+// SharedContainer is a container shared with a user.
+type SharedContainer struct {
+	Container api.Instance
+	Role      string
+}
+
+func queryShares(query string, args ...any) ([]DBShare, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var shares []DBShare
+	for rows.Next() {
+		var share DBShare
+		if err = rows.Scan(&share.Instance, &share.Username, &share.Role, &share.CreatedBy, &share.CreatedAt); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// ListSharedContainers returns the containers shared with username.
+func ListSharedContainers(ctx context.Context, username string) ([]SharedContainer, error) {
+	shares, err := queryShares("SELECT instance, username, role, created_by, created_at FROM container_shares WHERE username = ? ORDER BY instance", username)
+	if err != nil {
+		return nil, err
+	}
+	if len(shares) == 0 {
+		return nil, nil
+	}
+	containers, err := Client.ListAllContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var shared []SharedContainer
+	for _, share := range shares {
+		i := slices.IndexFunc(containers, func(container api.Instance) bool {
+			return container.Name == share.Instance
+		})
+		if i >= 0 {
+			shared = append(shared, SharedContainer{Container: containers[i], Role: share.Role})
+		}
+	}
+	return shared, nil
+}
+
+// ListAccessibleContainersFull returns the containers of username, of its
+// teams and those shared with it, along with their state.
+func ListAccessibleContainersFull(ctx context.Context, username string) ([]api.InstanceFull, error) {
+	owners, err := ContainerOwners(username)
+	if err != nil {
+		return nil, err
+	}
+	var containers []api.InstanceFull
+	for _, owner := range owners {
+		owned, err := Client.ListContainersFull(ctx, owner)
+		if err != nil {
+			return nil, err
+		}
+		containers = append(containers, owned...)
+	}
+	shared, err := ListSharedContainers(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	for _, share := range shared {
+		state, err := Client.GetContainerState(ctx, share.Container.Config["user.username"], share.Container.Name)
+		if errors.Is(err, lxc.ErrContainerNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		containers = append(containers, api.InstanceFull{Instance: share.Container, State: state})
+	}
+	return containers, nil
+}
+
+// GetAccessibleContainer returns the container called name among those of
+// username, of its teams and those shared with it for at least the share
+// role need. The user's own containers are preferred.
+func GetAccessibleContainer(ctx context.Context, username string, name string, need string) (*api.Instance, error) {
+	owners, err := ContainerOwners(username)
+	if err != nil {
+		return nil, err
+	}
+	for _, owner := range owners {
+		container, err := Client.GetContainer(ctx, owner, name)
+		if !errors.Is(err, lxc.ErrContainerNotFound) {
+			return container, err
+		}
+	}
+	shared, err := ListSharedContainers(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	var found *SharedContainer
+	for i, share := range shared {
+		if share.Container.Name == name {
+			found = &shared[i]
+			break
+		}
+		if share.Container.Config["user.friendlyname"] != name {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("friendly name %q is ambiguous, use the container name", name)
+		}
+		found = &shared[i]
+	}
+	if found == nil {
+		return nil, lxc.ErrContainerNotFound
+	}
+	if !ShareGrants(found.Role, need) {
+		return nil, fmt.Errorf("%s is shared with you with the %s role, %s is required", name, found.Role, need)
+	}
+	return &found.Container, nil
+}
+
+// getOwnedContainer returns the container called name if username owns it,
+// directly or as an owner of its team. action describes what ownership is
+// needed for in errors.
+func getOwnedContainer(ctx context.Context, username string, name string, action string) (*api.Instance, error) {
+	container, err := GetAccessibleContainer(ctx, username, name, ShareView)
+	if err != nil {
+		return nil, err
+	}
+	owner := container.Config["user.username"]
+	if owner == username {
+		return container, nil
+	}
+	team, ok := OwnerTeam(owner)
+	if !ok {
+		return nil, fmt.Errorf("%s is shared with you, only its owner can %s it", name, action)
+	}
+	isOwner, err := IsTeamOwner(team, username)
+	if err != nil {
+		return nil, err
+	}
+	if !isOwner {
+		return nil, fmt.Errorf("only owners of team %s can %s its containers", team, action)
+	}
+	return container, nil
+}
+
+// ShareContainer grants with the share role on the container name of
+// username, replacing any previous grant.
+func ShareContainer(ctx context.Context, username string, name string, with string, role string) error {
+	if !slices.Contains(ShareRoles, role) {
+		return ErrInvalidShareRole
+	}
+	container, err := getOwnedContainer(ctx, username, name, "share")
+	if err != nil {
+		return err
+	}
+	if with == container.Config["user.username"] {
+		return fmt.Errorf("%s already owns %s", with, name)
+	}
+	if err = checkUserExists(with); err != nil {
+		return err
+	}
+	_, err = DB.Exec("INSERT INTO container_shares (instance, username, role, created_by, created_at) VALUES (?, ?, ?, ?, ?) ON CONFLICT (instance, username) DO UPDATE SET role = excluded.role, created_by = excluded.created_by",
+		container.Name, with, role, username, time.Now().UTC())
+	return err
+}
+
+// UnshareContainer revokes the grant of with on the container name of
+// username.
+func UnshareContainer(ctx context.Context, username string, name string, with string) error {
+	container, err := getOwnedContainer(ctx, username, name, "unshare")
+	if err != nil {
+		return err
+	}
+	res, err := DB.Exec("DELETE FROM container_shares WHERE instance = ? AND username = ?", container.Name, with)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListContainerShares returns the grants on the container name of username.
+func ListContainerShares(ctx context.Context, username string, name string) ([]DBShare, error) {
+	container, err := getOwnedContainer(ctx, username, name, "manage")
+	if err != nil {
+		return nil, err
+	}
+	return queryShares("SELECT instance, username, role, created_by, created_at FROM container_shares WHERE instance = ? ORDER BY username", container.Name)
+}
+
+// deleteContainerShares removes the grants on a deleted container.
+func deleteContainerShares(name string) error {
+	_, err := DB.Exec("DELETE FROM container_shares WHERE instance = ?", name)
+	return err
+}
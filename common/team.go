@@ -0,0 +1,310 @@
+package common
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+)
+
+var (
+	ErrInvalidTeamName   = errors.New("team names must start with a lowercase letter and contain only lowercase letters, digits, - and _, up to 32 characters")
+	ErrTeamTaken         = errors.New("team name is already taken")
+	ErrTeamHasContainers = errors.New("team still owns containers, delete them first")
+	ErrLastTeamOwner     = errors.New("a team must keep at least one owner")
+)
+
+// teamOwnerPrefix marks containers owned by a team rather than a user in
+// their user.username config key. It can't clash with a username as those
+// don't contain colons.
+const teamOwnerPrefix = "team:"
+
+// TeamOwner returns the owner recorded on the containers of team.
+func TeamOwner(team string) string {
+	return teamOwnerPrefix + team
+}
+
+// OwnerTeam returns the team of a container owner, if it is one.
+func OwnerTeam(owner string) (string, bool) {
+	return strings.CutPrefix(owner, teamOwnerPrefix)
+}
+
+// DBTeam is a group of users sharing containers, with an instance quota of
+// its own.
+type DBTeam struct {
+	Name             string    `json:"name" yaml:"name"`
+	MaxInstanceCount int       `json:"max_instance_count" yaml:"max_instance_count"`
+	CreatedBy        string    `json:"created_by" yaml:"created_by"`
+	CreatedAt        time.Time `json:"created_at" yaml:"created_at"`
+}
+
+type DBTeamMember struct {
+	Team     string    `json:"team" yaml:"team"`
+	Username string    `json:"username" yaml:"username"`
+	Owner    bool      `json:"owner" yaml:"owner"`
+	AddedAt  time.Time `json:"added_at" yaml:"added_at"`
+}
+
+const teamFields = "teams.name, teams.max_instance_count, teams.created_by, teams.created_at"
+
+func scanTeam(row interface{ Scan(...any) error }) (DBTeam, error) {
+	var team DBTeam
+	err := row.Scan(&team.Name, &team.MaxInstanceCount, &team.CreatedBy, &team.CreatedAt)
+	return team, err
+}
+
+func queryTeams(query string, args ...any) ([]DBTeam, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var teams []DBTeam
+	for rows.Next() {
+		team, err := scanTeam(rows)
+		if err != nil {
+			return nil, err
+		}
+		teams = append(teams, team)
+	}
+	return teams, rows.Err()
+}
+
+// CreateTeam creates a team owned by owner.
+func CreateTeam(name string, owner string, maxInstanceCount int, createdBy string) error {
+	if !usernamePattern.MatchString(name) {
+		return ErrInvalidTeamName
+	}
+	if err := checkUserExists(owner); err != nil {
+		return err
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var n int
+	if err = tx.QueryRow("SELECT COUNT(*) FROM teams WHERE name = ?", name).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrTeamTaken
+	}
+	now := time.Now().UTC()
+	if _, err = tx.Exec("INSERT INTO teams (name, max_instance_count, created_by, created_at) VALUES (?, ?, ?, ?)", name, maxInstanceCount, createdBy, now); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("INSERT INTO team_members (team, username, owner, added_at) VALUES (?, ?, TRUE, ?)", name, owner, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteTeam deletes a team without containers and its memberships.
+func DeleteTeam(ctx context.Context, name string) error {
+	containers, err := Client.ListContainers(ctx, TeamOwner(name))
+	if err != nil {
+		return err
+	}
+	if len(containers) > 0 {
+		return fmt.Errorf("%w: %d container(s)", ErrTeamHasContainers, len(containers))
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec("DELETE FROM team_members WHERE team = ?", name); err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM teams WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+func GetTeam(name string) (DBTeam, error) {
+	return scanTeam(DB.QueryRow("SELECT "+teamFields+" FROM teams WHERE name = ?", name))
+}
+
+func ListTeams() ([]DBTeam, error) {
+	return queryTeams("SELECT " + teamFields + " FROM teams ORDER BY name")
+}
+
+// ListUserTeams returns the teams username is a member of.
+func ListUserTeams(username string) ([]DBTeam, error) {
+	return queryTeams("SELECT "+teamFields+" FROM teams JOIN team_members ON team_members.team = teams.name WHERE team_members.username = ? ORDER BY teams.name", username)
+}
+
+func ChangeTeamMaxInstanceCount(name string, maxInstanceCount int) error {
+	res, err := DB.Exec("UPDATE teams SET max_instance_count = ? WHERE name = ?", maxInstanceCount, name)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func ListTeamMembers(team string) ([]DBTeamMember, error) {
+	rows, err := DB.Query("SELECT team, username, owner, added_at FROM team_members WHERE team = ? ORDER BY owner DESC, username", team)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var members []DBTeamMember
+	for rows.Next() {
+		var member DBTeamMember
+		if err = rows.Scan(&member.Team, &member.Username, &member.Owner, &member.AddedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// IsTeamOwner reports whether username owns team.
+func IsTeamOwner(team string, username string) (bool, error) {
+	var owner bool
+	err := DB.QueryRow("SELECT owner FROM team_members WHERE team = ? AND username = ?", team, username).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return owner, err
+}
+
+// AddTeamMember adds username to team, or changes whether an existing
+// member is an owner.
+func AddTeamMember(team string, username string, owner bool) error {
+	if _, err := GetTeam(team); err != nil {
+		return err
+	}
+	if err := checkUserExists(username); err != nil {
+		return err
+	}
+	if !owner {
+		if err := checkNotLastOwner(team, username); err != nil {
+			return err
+		}
+	}
+	_, err := DB.Exec("INSERT INTO team_members (team, username, owner, added_at) VALUES (?, ?, ?, ?) ON CONFLICT (team, username) DO UPDATE SET owner = excluded.owner",
+		team, username, owner, time.Now().UTC())
+	return err
+}
+
+// RemoveTeamMember removes username from team. The last owner can't be
+// removed.
+func RemoveTeamMember(team string, username string) error {
+	if err := checkNotLastOwner(team, username); err != nil {
+		return err
+	}
+	res, err := DB.Exec("DELETE FROM team_members WHERE team = ? AND username = ?", team, username)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func checkUserExists(username string) error {
+	_, err := GetUser(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("user %s not found", username)
+	}
+	return err
+}
+
+// checkNotLastOwner returns ErrLastTeamOwner if username is the only owner
+// of team.
+func checkNotLastOwner(team string, username string) error {
+	var owners, isOwner int
+	err := DB.QueryRow("SELECT COUNT(*), COUNT(CASE WHEN username = ? THEN 1 END) FROM team_members WHERE team = ? AND owner",
+		username, team).Scan(&owners, &isOwner)
+	if err != nil {
+		return err
+	}
+	if isOwner > 0 && owners == 1 {
+		return ErrLastTeamOwner
+	}
+	return nil
+}
+
+// checkNotSoleTeamOwner returns ErrLastTeamOwner if username is the only
+// owner of a team, which would be left without owners by deleting the user.
+func checkNotSoleTeamOwner(username string) error {
+	rows, err := DB.Query("SELECT team FROM team_members WHERE owner GROUP BY team HAVING COUNT(*) = 1 AND MAX(username = ?) ORDER BY team", username)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var teams []string
+	for rows.Next() {
+		var team string
+		if err = rows.Scan(&team); err != nil {
+			return err
+		}
+		teams = append(teams, team)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if len(teams) > 0 {
+		return fmt.Errorf("%w: %s is the only owner of %s, make another member an owner first", ErrLastTeamOwner, username, strings.Join(teams, ", "))
+	}
+	return nil
+}
+
+// ContainerOwners returns the owners whose containers username can use:
+// the user itself followed by its teams.
+func ContainerOwners(username string) ([]string, error) {
+	teams, err := ListUserTeams(username)
+	if err != nil {
+		return nil, err
+	}
+	owners := []string{username}
+	for _, team := range teams {
+		owners = append(owners, TeamOwner(team.Name))
+	}
+	return owners, nil
+}
+
+// ListAccessibleContainers returns the containers of username and of its
+// teams.
+func ListAccessibleContainers(ctx context.Context, username string) ([]api.Instance, error) {
+	owners, err := ContainerOwners(username)
+	if err != nil {
+		return nil, err
+	}
+	var containers []api.Instance
+	for _, owner := range owners {
+		owned, err := Client.ListContainers(ctx, owner)
+		if err != nil {
+			return nil, err
+		}
+		containers = append(containers, owned...)
+	}
+	return containers, nil
+}
@@ -1,14 +1,22 @@
 package common
 
 import (
+	"bytes"
 	"io"
 	"strings"
+	"sync"
 )
 
+// ctrlC is the byte sent by the terminal when the user presses Ctrl-C.
+const ctrlC = 0x03
+
 type InterruptibleReader struct {
 	dataChan chan []byte
 	errChan  chan error
 	buf      []byte
+
+	interruptMutex sync.Mutex
+	onInterrupt    func()
 }
 
 func NewInterruptibleReader(reader io.Reader) *InterruptibleReader {
@@ -31,6 +39,16 @@ func (r *InterruptibleReader) readFromReader(reader io.Reader) {
 		}
 		data := make([]byte, n)
 		copy(data, buf[:n])
+		r.interruptMutex.Lock()
+		onInterrupt := r.onInterrupt
+		r.interruptMutex.Unlock()
+		if onInterrupt != nil && bytes.IndexByte(data, ctrlC) >= 0 {
+			onInterrupt()
+			data = bytes.ReplaceAll(data, []byte{ctrlC}, nil)
+			if len(data) == 0 {
+				continue
+			}
+		}
 		r.dataChan <- data
 	}
 }
@@ -67,6 +85,23 @@ func (r *InterruptibleReader) SendEOF() {
 	r.errChan <- io.EOF
 }
 
+// CancelRead makes a pending Read return io.EOF. It returns without effect
+// once done is closed, so it does not block if the read already completed.
+func (r *InterruptibleReader) CancelRead(done <-chan struct{}) {
+	select {
+	case r.errChan <- io.EOF:
+	case <-done:
+	}
+}
+
+// SetInterruptHandler makes Ctrl-C call f instead of being passed to readers.
+// A nil f passes Ctrl-C through again.
+func (r *InterruptibleReader) SetInterruptHandler(f func()) {
+	r.interruptMutex.Lock()
+	defer r.interruptMutex.Unlock()
+	r.onInterrupt = f
+}
+
 func WordWrap(text string, lineWidth int) string {
 	var result strings.Builder
 	var currentLineLength int
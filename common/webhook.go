@@ -0,0 +1,101 @@
+package common
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+type DBWebhook struct {
+	ID        int64     `json:"id" yaml:"id"`
+	URL       string    `json:"url" yaml:"url"`
+	Secret    string    `json:"-" yaml:"-"`
+	Events    []string  `json:"events" yaml:"events"`
+	CreatedAt time.Time `json:"created_at" yaml:"created_at"`
+}
+
+// Matches reports whether the webhook subscribes to eventType. A webhook
+// without filters receives every event.
+func (w DBWebhook) Matches(eventType string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, eventType)
+}
+
+type DBWebhookDelivery struct {
+	ID         int64     `json:"id" yaml:"id"`
+	WebhookID  int64     `json:"webhook_id" yaml:"webhook_id"`
+	Event      string    `json:"event" yaml:"event"`
+	Instance   string    `json:"instance" yaml:"instance"`
+	Attempt    int       `json:"attempt" yaml:"attempt"`
+	StatusCode int       `json:"status_code" yaml:"status_code"`
+	Error      string    `json:"error" yaml:"error"`
+	CreatedAt  time.Time `json:"created_at" yaml:"created_at"`
+}
+
+func AddWebhook(url string, secret string, events []string) (int64, error) {
+	res, err := DB.Exec("INSERT INTO webhooks (url, secret, events) VALUES (?, ?, ?)", url, secret, strings.Join(events, ","))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func ListWebhooks() ([]DBWebhook, error) {
+	rows, err := DB.Query("SELECT id, url, secret, events, created_at FROM webhooks")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var webhooks []DBWebhook
+	for rows.Next() {
+		var webhook DBWebhook
+		var events string
+		if err = rows.Scan(&webhook.ID, &webhook.URL, &webhook.Secret, &events, &webhook.CreatedAt); err != nil {
+			return nil, err
+		}
+		if events != "" {
+			webhook.Events = strings.Split(events, ",")
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+func DeleteWebhook(id int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM webhooks WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func AddWebhookDelivery(delivery DBWebhookDelivery) error {
+	_, err := DB.Exec("INSERT INTO webhook_deliveries (webhook_id, event, instance, attempt, status_code, error) VALUES (?, ?, ?, ?, ?, ?)",
+		delivery.WebhookID, delivery.Event, delivery.Instance, delivery.Attempt, delivery.StatusCode, delivery.Error)
+	return err
+}
+
+// ListWebhookDeliveries returns the latest deliveries, of every webhook if
+// webhookID is 0.
+func ListWebhookDeliveries(webhookID int64, limit int) ([]DBWebhookDelivery, error) {
+	rows, err := DB.Query("SELECT id, webhook_id, event, instance, attempt, status_code, error, created_at FROM webhook_deliveries WHERE ? = 0 OR webhook_id = ? ORDER BY id DESC LIMIT ?", webhookID, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deliveries []DBWebhookDelivery
+	for rows.Next() {
+		var delivery DBWebhookDelivery
+		if err = rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.Instance, &delivery.Attempt, &delivery.StatusCode, &delivery.Error, &delivery.CreatedAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,59 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// LoginCodeTTL is how long a one-time web login code stays valid.
+const LoginCodeTTL = 5 * time.Minute
+
+type loginCode struct {
+	username string
+	expires  time.Time
+}
+
+var (
+	loginCodes     = make(map[string]loginCode)
+	loginCodesLock sync.Mutex
+)
+
+// CreateLoginCode returns a one-time code that logs username into the web
+// terminal.
+func CreateLoginCode(username string) (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := hex.EncodeToString(buf)
+	loginCodesLock.Lock()
+	defer loginCodesLock.Unlock()
+	now := time.Now()
+	for c, l := range loginCodes {
+		if now.After(l.expires) {
+			delete(loginCodes, c)
+		}
+	}
+	loginCodes[code] = loginCode{
+		username: username,
+		expires:  now.Add(LoginCodeTTL),
+	}
+	return code, nil
+}
+
+// RedeemLoginCode consumes code and returns the user it was issued to.
+func RedeemLoginCode(code string) (string, bool) {
+	loginCodesLock.Lock()
+	defer loginCodesLock.Unlock()
+	l, ok := loginCodes[code]
+	if !ok {
+		return "", false
+	}
+	delete(loginCodes, code)
+	if time.Now().After(l.expires) {
+		return "", false
+	}
+	return l.username, true
+}
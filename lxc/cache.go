@@ -0,0 +1,158 @@
+package lxc
+
+import (
+	"lxcpanel/metrics"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+)
+
+// parseSSHPort returns the host port of the ssh proxy device, or 0 if there
+// is none.
+func parseSSHPort(devices map[string]map[string]string) int {
+	device, ok := devices["port22"]
+	if !ok || device["type"] != "proxy" {
+		return 0
+	}
+	parsed := strings.Split(device["listen"], ":")
+	port, err := strconv.Atoi(parsed[len(parsed)-1])
+	if err != nil {
+		return 0
+	}
+	return port
+}
+
+// syncCache reloads every instance from LXD.
+func (c *LXCClient) syncCache() error {
+	start := time.Now()
+	instances, err := c.client.GetInstances(api.InstanceTypeAny)
+	metrics.ObserveLXD("list", start, err)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, instance := range instances {
+		seen[instance.Name] = true
+		c.updateCache(instance.Name, &instance)
+	}
+	c.cacheMutex.Lock()
+	var gone []string
+	for name := range c.instances {
+		if !seen[name] {
+			gone = append(gone, name)
+		}
+	}
+	c.cacheMutex.Unlock()
+	for _, name := range gone {
+		c.updateCache(name, nil)
+	}
+	return nil
+}
+
+// refresh reloads a single instance from LXD, dropping it from the cache if
+// it no longer exists.
+func (c *LXCClient) refresh(name string) (api.Instance, bool) {
+	start := time.Now()
+	instance, _, err := c.client.GetInstance(name)
+	metrics.ObserveLXD("get", start, err)
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			c.updateCache(name, nil)
+		}
+		return api.Instance{}, false
+	}
+	c.updateCache(name, instance)
+	return *instance, true
+}
+
+// updateCache stores instance under name, or removes it if instance is nil,
+// and keeps the ssh port allocator in line with its proxy device.
+func (c *LXCClient) updateCache(name string, instance *api.Instance) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+	oldPort := 0
+	if old, ok := c.instances[name]; ok {
+		oldPort = parseSSHPort(old.Devices)
+	}
+	newPort := 0
+	if instance != nil {
+		newPort = parseSSHPort(instance.Devices)
+		c.instances[name] = *instance
+	} else {
+		delete(c.instances, name)
+	}
+	if oldPort == newPort {
+		if newPort > 0 {
+			c.reservePort(newPort)
+		}
+		return
+	}
+	if oldPort > 0 {
+		c.ReleasePort(oldPort)
+	}
+	if newPort > 0 {
+		c.reservePort(newPort)
+	}
+}
+
+func (c *LXCClient) cached(name string) (api.Instance, bool) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+	instance, ok := c.instances[name]
+	return instance, ok
+}
+
+// setCacheLive records whether the LXD event stream is connected, i.e.
+// whether the cache can be trusted to answer reads.
+func (c *LXCClient) setCacheLive(live bool) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+	c.cacheLive = live
+}
+
+// cachedContainer looks up a single instance. live is false if the cache is
+// not being kept up to date and must not be used.
+func (c *LXCClient) cachedContainer(name string) (instance api.Instance, ok bool, live bool) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+	if !c.cacheLive {
+		return api.Instance{}, false, false
+	}
+	instance, ok = c.instances[name]
+	return instance, ok, true
+}
+
+// cachedContainers returns the containers owned by username, or every
+// panel-owned container if username is empty, sorted by name. ok is false if
+// the cache is not being kept up to date.
+func (c *LXCClient) cachedContainers(username string) ([]api.Instance, bool) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+	if !c.cacheLive {
+		return nil, false
+	}
+	containers := []api.Instance{}
+	for _, instance := range c.instances {
+		if instance.Type != string(api.InstanceTypeContainer) {
+			continue
+		}
+		owner := instance.Config["user.username"]
+		if owner != "" && (username == "" || owner == username) {
+			containers = append(containers, instance)
+		}
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Name < containers[j].Name
+	})
+	return containers, true
+}
+
+func (c *LXCClient) reservePort(port int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.usedPorts[port] = true
+}
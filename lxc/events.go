@@ -0,0 +1,204 @@
+package lxc
+
+import (
+	"encoding/json"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/charmbracelet/log"
+)
+
+const (
+	EventInstanceCreated = "instance-created"
+	EventInstanceStarted = "instance-started"
+	EventInstanceStopped = "instance-stopped"
+	EventInstanceDeleted = "instance-deleted"
+)
+
+// EventTypes lists every event type published by the client.
+var EventTypes = []string{
+	EventInstanceCreated,
+	EventInstanceStarted,
+	EventInstanceStopped,
+	EventInstanceDeleted,
+}
+
+const (
+	// EventSourcePanel marks events caused by a panel command.
+	EventSourcePanel = "panel"
+	// EventSourceLXD marks events seen on the LXD event stream that were not
+	// caused by the panel, e.g. changes made with the native lxc CLI.
+	EventSourceLXD = "lxd"
+)
+
+// eventDedupWindow is how long an action completed by the panel keeps
+// suppressing the matching events from the LXD stream.
+const eventDedupWindow = 30 * time.Second
+
+// Event describes a lifecycle change of a panel-owned instance.
+type Event struct {
+	Type         string    `json:"event" yaml:"event"`
+	Instance     string    `json:"instance" yaml:"instance"`
+	FriendlyName string    `json:"friendly_name" yaml:"friendly_name"`
+	Username     string    `json:"username" yaml:"username"`
+	Source       string    `json:"source" yaml:"source"`
+	Timestamp    time.Time `json:"timestamp" yaml:"timestamp"`
+}
+
+// Subscribe registers f to be called for every instance lifecycle event and
+// returns a function removing it again. Handlers are called synchronously and
+// must not block.
+func (c *LXCClient) Subscribe(f func(Event)) func() {
+	c.eventMutex.Lock()
+	defer c.eventMutex.Unlock()
+	id := c.nextHandlerID
+	c.nextHandlerID++
+	c.eventHandlers[id] = f
+	return func() {
+		c.eventMutex.Lock()
+		defer c.eventMutex.Unlock()
+		delete(c.eventHandlers, id)
+	}
+}
+
+// expect marks an event the panel is about to cause, so that the matching
+// event from the LXD stream is not reported a second time. The mark does not
+// expire until the action is published or fails.
+func (c *LXCClient) expect(eventType string, name string) {
+	c.eventMutex.Lock()
+	defer c.eventMutex.Unlock()
+	for k, t := range c.expectedEvents {
+		if !t.IsZero() && time.Since(t) > eventDedupWindow {
+			delete(c.expectedEvents, k)
+		}
+	}
+	c.expectedEvents[eventType+"/"+name] = time.Time{}
+}
+
+// unexpect forgets an expected event whose action failed.
+func (c *LXCClient) unexpect(eventType string, name string) {
+	c.eventMutex.Lock()
+	defer c.eventMutex.Unlock()
+	delete(c.expectedEvents, eventType+"/"+name)
+}
+
+// publish notifies subscribers of an event caused by the panel itself.
+func (c *LXCClient) publish(eventType string, instance api.Instance) {
+	c.eventMutex.Lock()
+	c.expectedEvents[eventType+"/"+instance.Name] = time.Now()
+	c.eventMutex.Unlock()
+	c.dispatch(eventType, instance, EventSourcePanel)
+}
+
+func (c *LXCClient) dispatch(eventType string, instance api.Instance, source string) {
+	username := instance.Config["user.username"]
+	if username == "" {
+		// Not a panel-owned instance
+		return
+	}
+	event := Event{
+		Type:         eventType,
+		Instance:     instance.Name,
+		FriendlyName: instance.Config["user.friendlyname"],
+		Username:     username,
+		Source:       source,
+		Timestamp:    time.Now(),
+	}
+	c.eventMutex.Lock()
+	handlers := make([]func(Event), 0, len(c.eventHandlers))
+	for _, f := range c.eventHandlers {
+		handlers = append(handlers, f)
+	}
+	c.eventMutex.Unlock()
+	for _, f := range handlers {
+		f(event)
+	}
+}
+
+// WatchEvents follows the LXD lifecycle event stream to keep the instance
+// cache up to date, reconnecting when the connection is lost. It never
+// returns.
+func (c *LXCClient) WatchEvents() {
+	for {
+		listener, err := c.client.GetEvents()
+		if err == nil {
+			_, err = listener.AddHandler([]string{"lifecycle"}, c.handleLifecycle)
+			if err == nil {
+				// Catch up on changes missed while disconnected
+				err = c.syncCache()
+			}
+			if err == nil {
+				c.setCacheLive(true)
+				err = listener.Wait()
+				c.setCacheLive(false)
+			}
+			listener.Disconnect()
+		}
+		log.Error("LXD event stream disconnected", "error", err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (c *LXCClient) handleLifecycle(e api.Event) {
+	var lifecycle api.EventLifecycle
+	if err := json.Unmarshal(e.Metadata, &lifecycle); err != nil {
+		return
+	}
+	name := lifecycle.Name
+	if name == "" {
+		source, err := url.Parse(lifecycle.Source)
+		if err != nil {
+			return
+		}
+		name = path.Base(source.Path)
+	}
+
+	var eventType string
+	switch lifecycle.Action {
+	case api.EventLifecycleInstanceCreated:
+		eventType = EventInstanceCreated
+	case api.EventLifecycleInstanceStarted:
+		eventType = EventInstanceStarted
+	case api.EventLifecycleInstanceStopped, api.EventLifecycleInstanceShutdown:
+		eventType = EventInstanceStopped
+	case api.EventLifecycleInstanceDeleted:
+		eventType = EventInstanceDeleted
+	case api.EventLifecycleInstanceUpdated:
+		// Devices may have changed, e.g. the ssh proxy port
+		c.refresh(name)
+		return
+	case api.EventLifecycleInstanceRenamed:
+		if err := c.syncCache(); err != nil {
+			log.Error("Error syncing instance cache", "error", err)
+		}
+		return
+	default:
+		return
+	}
+
+	var instance api.Instance
+	if eventType == EventInstanceDeleted {
+		var ok bool
+		instance, ok = c.cached(name)
+		if !ok {
+			return
+		}
+		c.updateCache(name, nil)
+	} else {
+		var ok bool
+		instance, ok = c.refresh(name)
+		if !ok {
+			return
+		}
+	}
+
+	c.eventMutex.Lock()
+	expected, ok := c.expectedEvents[eventType+"/"+name]
+	c.eventMutex.Unlock()
+	if ok && (expected.IsZero() || time.Since(expected) < eventDedupWindow) {
+		return
+	}
+	c.dispatch(eventType, instance, EventSourceLXD)
+}
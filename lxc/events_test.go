@@ -0,0 +1,122 @@
+package lxc
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+)
+
+// lifecycleEvent returns the LXD stream event of action on instance name.
+func lifecycleEvent(t *testing.T, action string, name string) api.Event {
+	t.Helper()
+	metadata, err := json.Marshal(api.EventLifecycle{Action: action, Name: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api.Event{Type: api.EventTypeLifecycle, Metadata: metadata}
+}
+
+// subscribe returns the events dispatched by c during the test.
+func subscribe(t *testing.T, c *LXCClient) *[]Event {
+	var events []Event
+	t.Cleanup(c.Subscribe(func(e Event) {
+		events = append(events, e)
+	}))
+	return &events
+}
+
+func TestLifecycleDedupe(t *testing.T) {
+	started := api.EventLifecycleInstanceStarted
+	tests := []struct {
+		name string
+		// prepare runs before the LXD event of c0000 arrives
+		prepare func(c *LXCClient, instance api.Instance)
+		// sources of the events dispatched, in order
+		want []string
+	}{
+		{name: "external", want: []string{EventSourceLXD}},
+		{name: "expected", want: nil, prepare: func(c *LXCClient, instance api.Instance) {
+			c.expect(EventInstanceStarted, instance.Name)
+		}},
+		{name: "published", want: []string{EventSourcePanel}, prepare: func(c *LXCClient, instance api.Instance) {
+			c.expect(EventInstanceStarted, instance.Name)
+			c.publish(EventInstanceStarted, instance)
+		}},
+		{name: "failed", want: []string{EventSourceLXD}, prepare: func(c *LXCClient, instance api.Instance) {
+			c.expect(EventInstanceStarted, instance.Name)
+			c.unexpect(EventInstanceStarted, instance.Name)
+		}},
+		{name: "other instance", want: []string{EventSourceLXD}, prepare: func(c *LXCClient, instance api.Instance) {
+			c.expect(EventInstanceStarted, "c0001")
+		}},
+		{name: "other event", want: []string{EventSourceLXD}, prepare: func(c *LXCClient, instance api.Instance) {
+			c.expect(EventInstanceStopped, instance.Name)
+		}},
+		{name: "published long ago", want: []string{EventSourceLXD}, prepare: func(c *LXCClient, instance api.Instance) {
+			c.expectedEvents[EventInstanceStarted+"/"+instance.Name] = time.Now().Add(-eventDedupWindow - time.Second)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(1, 2)
+			c := newCachedClient(t, server, true)
+			events := subscribe(t, c)
+			if tt.prepare != nil {
+				tt.prepare(c, server.instances[0])
+			}
+			c.handleLifecycle(lifecycleEvent(t, started, "c0000"))
+
+			var sources []string
+			for _, e := range *events {
+				if e.Type != EventInstanceStarted || e.Instance != "c0000" || e.Username != "user0" {
+					t.Errorf("dispatched %+v", e)
+				}
+				sources = append(sources, e.Source)
+			}
+			if len(sources) != len(tt.want) {
+				t.Fatalf("dispatched events from %v, want %v", sources, tt.want)
+			}
+			for i := range sources {
+				if sources[i] != tt.want[i] {
+					t.Fatalf("dispatched events from %v, want %v", sources, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestExpectForgetsExpiredMarks(t *testing.T) {
+	c := newClient(nil, "default", "")
+	c.expectedEvents[EventInstanceStarted+"/old"] = time.Now().Add(-eventDedupWindow - time.Second)
+	c.expectedEvents[EventInstanceStarted+"/recent"] = time.Now()
+	c.expect(EventInstanceStopped, "pending")
+	c.expect(EventInstanceStarted, "new")
+
+	if _, ok := c.expectedEvents[EventInstanceStarted+"/old"]; ok {
+		t.Error("expired mark kept")
+	}
+	for _, key := range []string{EventInstanceStarted + "/recent", EventInstanceStopped + "/pending", EventInstanceStarted + "/new"} {
+		if _, ok := c.expectedEvents[key]; !ok {
+			t.Errorf("mark %s forgotten", key)
+		}
+	}
+}
+
+func TestLifecycleDeletedFromCache(t *testing.T) {
+	server := newFakeServer(1, 1)
+	c := newCachedClient(t, server, true)
+	events := subscribe(t, c)
+	// The instance is gone by the time the event arrives
+	server.instances = nil
+	c.handleLifecycle(lifecycleEvent(t, api.EventLifecycleInstanceDeleted, "c0000"))
+
+	if len(*events) != 1 || (*events)[0].Type != EventInstanceDeleted || (*events)[0].FriendlyName != "box0" {
+		t.Fatalf("dispatched %+v", *events)
+	}
+	if _, ok := c.cached("c0000"); ok {
+		t.Error("deleted instance still cached")
+	}
+	checkRequests(t, server, 0, 0)
+}
@@ -5,9 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"lxcpanel/metrics"
+	"net/http"
+	"sort"
 	"strconv"
-	"strings"
 	"sync"
+	"time"
 
 	lxd "github.com/canonical/lxd/client"
 	"github.com/canonical/lxd/shared/api"
@@ -15,12 +18,29 @@ import (
 	"github.com/lithammer/shortuuid/v4"
 )
 
+const (
+	// SSH ports are allocated from [sshPortLow, sshPortHigh).
+	sshPortLow  = 22000
+	sshPortHigh = 23000
+)
+
+var ErrContainerNotFound = errors.New("container not found")
+
 type LXCClient struct {
 	client         lxd.InstanceServer
 	usedPorts      map[int]bool
 	mutex          sync.Mutex
 	defaultProfile string
 	defaultImage   string
+
+	cacheMutex sync.Mutex
+	instances  map[string]api.Instance
+	cacheLive  bool
+
+	eventMutex     sync.Mutex
+	eventHandlers  map[int]func(Event)
+	nextHandlerID  int
+	expectedEvents map[string]time.Time
 }
 
 func NewLXCClient(defaultProfile string, defaultImage string) (*LXCClient, error) {
@@ -28,61 +48,163 @@ func NewLXCClient(defaultProfile string, defaultImage string) (*LXCClient, error
 	if err != nil {
 		return nil, err
 	}
-	usedPorts := make(map[int]bool)
-	containers, err := client.GetInstances(api.InstanceTypeAny)
-	if err != nil {
+	c := newClient(client, defaultProfile, defaultImage)
+	// Scan all instances and their ssh ports
+	if err = c.syncCache(); err != nil {
 		return nil, err
 	}
-	// Scan all ssh ports
-	for _, container := range containers {
-		for name, device := range container.Devices {
-			if name == "port22" && device["type"] == "proxy" {
-				listen := device["listen"]
-				parsed := strings.Split(listen, ":")
-				if len(parsed) > 0 {
-					port, err := strconv.Atoi(parsed[len(parsed)-1])
-					if err == nil {
-						usedPorts[port] = true
-					}
-				}
-			}
-		}
-	}
+	return c, nil
+}
+
+func newClient(client lxd.InstanceServer, defaultProfile string, defaultImage string) *LXCClient {
 	return &LXCClient{
 		client:         client,
-		usedPorts:      usedPorts,
+		usedPorts:      make(map[int]bool),
 		mutex:          sync.Mutex{},
 		defaultProfile: defaultProfile,
 		defaultImage:   defaultImage,
-	}, nil
+		instances:      make(map[string]api.Instance),
+		eventHandlers:  make(map[int]func(Event)),
+		expectedEvents: make(map[string]time.Time),
+	}
 }
 
 func (c *LXCClient) DefaultImage() string {
 	return c.defaultImage
 }
 
-func (c *LXCClient) ListContainers(username string) ([]api.Instance, error) {
-	containers, err := c.client.GetInstancesWithFilter(api.InstanceTypeContainer, []string{"config.user.username=" + username})
+// ListContainers returns the containers owned by username. While the LXD
+// event stream is connected this is served from the instance cache without
+// any LXD request.
+func (c *LXCClient) ListContainers(ctx context.Context, username string) ([]api.Instance, error) {
+	if containers, ok := c.cachedContainers(username); ok {
+		return containers, nil
+	}
+	start := time.Now()
+	containers, err := interruptible(ctx, func() ([]api.Instance, error) {
+		return c.client.GetInstancesWithFilter(api.InstanceTypeContainer, []string{"config.user.username=" + username})
+	})
+	metrics.ObserveLXD("list", start, err)
 	if err != nil {
 		return nil, err
 	}
 	return containers, nil
 }
 
-func (c *LXCClient) GetContainer(username string, name string) (*api.Instance, error) {
-	containers, err := c.ListContainers(username)
+// ListAllContainers returns the containers of every user.
+func (c *LXCClient) ListAllContainers(ctx context.Context) ([]api.Instance, error) {
+	if containers, ok := c.cachedContainers(""); ok {
+		return containers, nil
+	}
+	start := time.Now()
+	instances, err := interruptible(ctx, func() ([]api.Instance, error) {
+		return c.client.GetInstances(api.InstanceTypeContainer)
+	})
+	metrics.ObserveLXD("list", start, err)
 	if err != nil {
 		return nil, err
 	}
-	for _, container := range containers {
+	var containers []api.Instance
+	for _, instance := range instances {
+		if instance.Config["user.username"] != "" {
+			containers = append(containers, instance)
+		}
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Name < containers[j].Name
+	})
+	return containers, nil
+}
+
+// FindContainer returns the container called name, whoever owns it.
+func (c *LXCClient) FindContainer(ctx context.Context, name string) (*api.Instance, error) {
+	containers, err := c.ListAllContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i, container := range containers {
 		if container.Name == name {
-			return &container, nil
+			return &containers[i], nil
 		}
 	}
-	return nil, errors.New("container not found")
+	return nil, ErrContainerNotFound
 }
 
-func (c *LXCClient) CreateContainer(username string, friendlyname string, fingerprint string) (lxd.Operation, error) {
+// GetContainer returns the container owned by username whose name, or
+// otherwise unique friendly name, is name.
+func (c *LXCClient) GetContainer(ctx context.Context, username string, name string) (*api.Instance, error) {
+	container, ok, live := c.cachedContainer(name)
+	if !live {
+		start := time.Now()
+		instance, err := interruptible(ctx, func() (*api.Instance, error) {
+			instance, _, err := c.client.GetInstance(name)
+			return instance, err
+		})
+		metrics.ObserveLXD("get", start, err)
+		if err != nil && !api.StatusErrorCheck(err, http.StatusNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			container, ok = *instance, true
+		}
+	}
+	if ok && container.Type == string(api.InstanceTypeContainer) && container.Config["user.username"] == username {
+		return &container, nil
+	}
+	return c.getContainerByFriendlyName(ctx, username, name)
+}
+
+func (c *LXCClient) getContainerByFriendlyName(ctx context.Context, username string, friendlyname string) (*api.Instance, error) {
+	containers, err := c.ListContainers(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	var found *api.Instance
+	for i, container := range containers {
+		if container.Config["user.friendlyname"] != friendlyname {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("friendly name %q is ambiguous, use the container name", friendlyname)
+		}
+		found = &containers[i]
+	}
+	if found == nil {
+		return nil, ErrContainerNotFound
+	}
+	return found, nil
+}
+
+func (c *LXCClient) ListContainersFull(ctx context.Context, username string) ([]api.InstanceFull, error) {
+	start := time.Now()
+	containers, err := interruptible(ctx, func() ([]api.InstanceFull, error) {
+		return c.client.GetInstancesFullWithFilter(api.InstanceTypeContainer, []string{"config.user.username=" + username})
+	})
+	metrics.ObserveLXD("list_full", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+func (c *LXCClient) GetContainerState(ctx context.Context, username string, name string) (*api.InstanceState, error) {
+	container, err := c.GetContainer(ctx, username, name)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	state, err := interruptible(ctx, func() (*api.InstanceState, error) {
+		state, _, err := c.client.GetInstanceState(container.Name)
+		return state, err
+	})
+	metrics.ObserveLXD("state", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (c *LXCClient) CreateContainer(ctx context.Context, username string, friendlyname string, fingerprint string) (lxd.Operation, error) {
 	instancePost := api.InstancesPost{
 		Name: shortuuid.New(),
 		Source: api.InstanceSource{
@@ -103,7 +225,7 @@ func (c *LXCClient) CreateContainer(username string, friendlyname string, finger
 	}
 
 	// Find an unused port for SSH
-	sshPort, err := c.UnusedPort(22000, 23000)
+	sshPort, err := c.UnusedPort(sshPortLow, sshPortHigh)
 	if err == nil {
 		instancePost.Devices["port22"] = map[string]string{
 			"type":    "proxy",
@@ -112,69 +234,173 @@ func (c *LXCClient) CreateContainer(username string, friendlyname string, finger
 		}
 	}
 
-	return c.client.CreateInstance(instancePost)
+	if err = ctx.Err(); err != nil {
+		if sshPort > 0 {
+			c.ReleasePort(sshPort)
+		}
+		return nil, err
+	}
+	c.expect(EventInstanceCreated, instancePost.Name)
+	start := time.Now()
+	op, err := c.client.CreateInstance(instancePost)
+	metrics.ObserveLXD("create", start, err)
+	if err != nil {
+		c.unexpect(EventInstanceCreated, instancePost.Name)
+		if sshPort > 0 {
+			c.ReleasePort(sshPort)
+		}
+		return nil, err
+	}
+	go func() {
+		if op.Wait() != nil {
+			c.unexpect(EventInstanceCreated, instancePost.Name)
+			if sshPort > 0 {
+				c.ReleasePort(sshPort)
+			}
+			return
+		}
+		if instance, ok := c.refresh(instancePost.Name); ok {
+			c.publish(EventInstanceCreated, instance)
+		}
+	}()
+	return op, nil
 }
 
-func (c *LXCClient) DeleteContainer(username string, name string) error {
-	container, err := c.GetContainer(username, name)
+func (c *LXCClient) DeleteContainer(ctx context.Context, username string, name string) error {
+	container, err := c.GetContainer(ctx, username, name)
 	if err != nil {
 		return err
 	}
-	sshPort := c.SSHPort(container.Name)
+	c.expect(EventInstanceDeleted, container.Name)
+	start := time.Now()
 	op, err := c.client.DeleteInstance(container.Name)
-	if err != nil {
-		return err
+	if err == nil {
+		err = Wait(ctx, op)
 	}
-	err = op.Wait()
+	metrics.ObserveLXD("delete", start, err)
 	if err != nil {
+		c.unexpect(EventInstanceDeleted, container.Name)
 		return err
 	}
-	// Release ssh port
-	if sshPort > 0 {
-		c.ReleasePort(sshPort)
-	}
+	// Drop the instance from the cache, releasing its ssh port
+	c.updateCache(container.Name, nil)
+	c.publish(EventInstanceDeleted, *container)
 	return nil
 }
 
-func (c *LXCClient) StartContainer(username string, name string) error {
-	container, err := c.GetContainer(username, name)
+func (c *LXCClient) StartContainer(ctx context.Context, username string, name string) error {
+	container, err := c.GetContainer(ctx, username, name)
 	if err != nil {
 		return err
 	}
+	c.expect(EventInstanceStarted, container.Name)
+	start := time.Now()
 	op, err := c.client.UpdateInstanceState(container.Name, api.InstanceStatePut{
 		Action: "start",
 	}, "")
-
+	if err == nil {
+		err = Wait(ctx, op)
+	}
+	metrics.ObserveLXD("start", start, err)
 	if err != nil {
+		c.unexpect(EventInstanceStarted, container.Name)
 		return err
 	}
-	return op.Wait()
+	if instance, ok := c.refresh(container.Name); ok {
+		container = &instance
+	}
+	c.publish(EventInstanceStarted, *container)
+	return nil
 }
 
-func (c *LXCClient) StopContainer(username string, name string) error {
-	container, err := c.GetContainer(username, name)
+func (c *LXCClient) StopContainer(ctx context.Context, username string, name string) error {
+	container, err := c.GetContainer(ctx, username, name)
 	if err != nil {
 		return err
 	}
+	c.expect(EventInstanceStopped, container.Name)
+	start := time.Now()
 	op, err := c.client.UpdateInstanceState(container.Name, api.InstanceStatePut{
 		Action: "stop",
 	}, "")
+	if err == nil {
+		err = Wait(ctx, op)
+	}
+	metrics.ObserveLXD("stop", start, err)
 	if err != nil {
+		c.unexpect(EventInstanceStopped, container.Name)
 		return err
 	}
-	return op.Wait()
+	if instance, ok := c.refresh(container.Name); ok {
+		container = &instance
+	}
+	c.publish(EventInstanceStopped, *container)
+	return nil
+}
+
+// SetOwner changes the owner recorded in the user.username config key of
+// the container name.
+func (c *LXCClient) SetOwner(ctx context.Context, name string, owner string) error {
+	start := time.Now()
+	instance, etag, err := c.client.GetInstance(name)
+	if err == nil {
+		instance.Config["user.username"] = owner
+		var op lxd.Operation
+		op, err = c.client.UpdateInstance(name, instance.Writable(), etag)
+		if err == nil {
+			err = Wait(ctx, op)
+		}
+	}
+	metrics.ObserveLXD("update", start, err)
+	if err != nil {
+		return err
+	}
+	c.refresh(name)
+	return nil
 }
 
-func (c *LXCClient) ListImages() ([]api.Image, error) {
-	images, err := c.client.GetImages()
+// ExportBackup writes a backup tarball of the container, including its
+// snapshots, to w. The backup is only kept on the LXD server while it is
+// downloaded.
+func (c *LXCClient) ExportBackup(ctx context.Context, username string, name string, w io.WriteSeeker) error {
+	container, err := c.GetContainer(ctx, username, name)
+	if err != nil {
+		return err
+	}
+	backupName := "export-" + shortuuid.New()
+	start := time.Now()
+	op, err := c.client.CreateInstanceBackup(container.Name, api.InstanceBackupsPost{
+		Name:      backupName,
+		ExpiresAt: time.Now().Add(24 * time.Hour),
+	})
+	if err == nil {
+		err = Wait(ctx, op)
+	}
+	metrics.ObserveLXD("backup", start, err)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if op, err := c.client.DeleteInstanceBackup(container.Name, backupName); err == nil {
+			op.Wait()
+		}
+	}()
+	_, err = c.client.GetInstanceBackupFile(container.Name, backupName, &lxd.BackupFileRequest{BackupFile: w})
+	return err
+}
+
+func (c *LXCClient) ListImages(ctx context.Context) ([]api.Image, error) {
+	start := time.Now()
+	images, err := interruptible(ctx, c.client.GetImages)
+	metrics.ObserveLXD("images", start, err)
 	if err != nil {
 		return nil, err
 	}
 	return images, nil
 }
 
-func (c *LXCClient) StartShell(name string, stdin io.Reader, stdout io.Writer, width int, height int, ch chan api.InstanceExecControl) error {
-	ctx, cancel := context.WithCancel(context.Background())
+func (c *LXCClient) StartShell(ctx context.Context, name string, stdin io.Reader, stdout io.Writer, width int, height int, ch chan api.InstanceExecControl) error {
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	dataDone := make(chan bool)
 	op, err := c.client.ExecInstance(name, api.InstanceExecPost{
@@ -213,7 +439,7 @@ func (c *LXCClient) StartShell(name string, stdin io.Reader, stdout io.Writer, w
 	if err != nil {
 		return err
 	}
-	err = op.Wait()
+	err = op.WaitContext(ctx)
 	if err != nil {
 		return err
 	}
@@ -221,24 +447,93 @@ func (c *LXCClient) StartShell(name string, stdin io.Reader, stdout io.Writer, w
 	return nil
 }
 
-func (c *LXCClient) SSHPort(name string) int {
-	container, _, err := c.client.GetInstance(name)
-	if err != nil {
-		return 0
-	}
-	for name, device := range container.Devices {
-		if name == "port22" && device["type"] == "proxy" {
-			listen := device["listen"]
-			parsed := strings.Split(listen, ":")
-			if len(parsed) > 0 {
-				port, err := strconv.Atoi(parsed[len(parsed)-1])
-				if err == nil {
-					return port
-				}
-			}
+// Wait waits for op to finish. If ctx is done first, LXD is asked to cancel
+// the operation, which only some operations support, and ctx's error is
+// returned.
+func Wait(ctx context.Context, op lxd.Operation) error {
+	err := op.WaitContext(ctx)
+	if ctx.Err() != nil {
+		_ = op.Cancel()
+		return ctx.Err()
+	}
+	return err
+}
+
+// interruptible returns the result of f, or ctx's error as soon as ctx is
+// done. Plain LXD requests cannot be cancelled, so f is then left to finish
+// in the background.
+func interruptible[T any](ctx context.Context, f func() (T, error)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := f()
+		done <- result{value, err}
+	}()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// WindowResize builds the exec control message resizing a shell's terminal.
+func WindowResize(width int, height int) api.InstanceExecControl {
+	return api.InstanceExecControl{
+		Command: "window-resize",
+		Args: map[string]string{
+			"width":  strconv.Itoa(width),
+			"height": strconv.Itoa(height),
+		},
+	}
+}
+
+// SSHPort returns the host port forwarded to the container's ssh server, or 0
+// if there is none.
+func SSHPort(container api.Instance) int {
+	return parseSSHPort(container.Devices)
+}
+
+// CountContainersByUser returns the number of panel-owned containers per user.
+func (c *LXCClient) CountContainersByUser() (map[string]int, error) {
+	containers, ok := c.cachedContainers("")
+	if !ok {
+		start := time.Now()
+		var err error
+		containers, err = c.client.GetInstances(api.InstanceTypeContainer)
+		metrics.ObserveLXD("list", start, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+	counts := make(map[string]int)
+	for _, container := range containers {
+		username := container.Config["user.username"]
+		if username != "" {
+			counts[username]++
+		}
+	}
+	return counts, nil
+}
+
+// PortUsage returns the number of allocated SSH ports and the size of the pool.
+func (c *LXCClient) PortUsage() (int, int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	used := 0
+	for port, inUse := range c.usedPorts {
+		if inUse && port >= sshPortLow && port < sshPortHigh {
+			used++
 		}
 	}
-	return 0
+	return used, sshPortHigh - sshPortLow
 }
 
 func (c *LXCClient) ReleasePort(port int) {
@@ -0,0 +1,180 @@
+package lxc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	lxd "github.com/canonical/lxd/client"
+	"github.com/canonical/lxd/shared/api"
+)
+
+// fakeServer serves a fixed set of instances and counts the requests that
+// read them. Any other LXD call panics.
+type fakeServer struct {
+	lxd.InstanceServer
+	instances    []api.Instance
+	getInstance  atomic.Int64
+	getInstances atomic.Int64
+}
+
+// newFakeServer returns a server with users owners of perUser containers
+// each, every one with an ssh proxy device.
+func newFakeServer(users int, perUser int) *fakeServer {
+	s := &fakeServer{}
+	for u := range users {
+		for i := range perUser {
+			n := u*perUser + i
+			s.instances = append(s.instances, api.Instance{
+				Name: fmt.Sprintf("c%04d", n),
+				Type: string(api.InstanceTypeContainer),
+				Config: map[string]string{
+					"user.username":     fmt.Sprintf("user%d", u),
+					"user.friendlyname": fmt.Sprintf("box%d", i),
+				},
+				Devices: map[string]map[string]string{
+					"port22": {"type": "proxy", "listen": fmt.Sprintf("tcp:0.0.0.0:%d", sshPortLow+n)},
+				},
+			})
+		}
+	}
+	return s
+}
+
+func (s *fakeServer) GetInstances(instanceType api.InstanceType) ([]api.Instance, error) {
+	s.getInstances.Add(1)
+	return append([]api.Instance(nil), s.instances...), nil
+}
+
+func (s *fakeServer) GetInstancesWithFilter(instanceType api.InstanceType, filters []string) ([]api.Instance, error) {
+	s.getInstances.Add(1)
+	var instances []api.Instance
+	for _, instance := range s.instances {
+		match := true
+		for _, filter := range filters {
+			key, value, _ := strings.Cut(filter, "=")
+			match = match && instance.Config[strings.TrimPrefix(key, "config.")] == value
+		}
+		if match {
+			instances = append(instances, instance)
+		}
+	}
+	return instances, nil
+}
+
+func (s *fakeServer) GetInstance(name string) (*api.Instance, string, error) {
+	s.getInstance.Add(1)
+	for _, instance := range s.instances {
+		if instance.Name == name {
+			return &instance, "", nil
+		}
+	}
+	return nil, "", api.StatusErrorf(http.StatusNotFound, "Instance not found")
+}
+
+// newCachedClient returns a client whose cache was filled from server,
+// live as if the event stream were connected or not, and resets the
+// request counts.
+func newCachedClient(tb testing.TB, server *fakeServer, live bool) *LXCClient {
+	tb.Helper()
+	c := newClient(server, "default", "")
+	if err := c.syncCache(); err != nil {
+		tb.Fatal(err)
+	}
+	c.setCacheLive(live)
+	server.getInstance.Store(0)
+	server.getInstances.Store(0)
+	return c
+}
+
+// checkRequests fails unless server got the given number of GetInstance and
+// GetInstances requests.
+func checkRequests(tb testing.TB, server *fakeServer, getInstance int64, getInstances int64) {
+	tb.Helper()
+	if got := server.getInstance.Load(); got != getInstance {
+		tb.Errorf("GetInstance called %d times, want %d", got, getInstance)
+	}
+	if got := server.getInstances.Load(); got != getInstances {
+		tb.Errorf("GetInstances called %d times, want %d", got, getInstances)
+	}
+}
+
+func listWithPorts(tb testing.TB, c *LXCClient, username string) {
+	containers, err := c.ListContainers(context.Background(), username)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, container := range containers {
+		if SSHPort(container) == 0 {
+			tb.Fatalf("no ssh port for %s", container.Name)
+		}
+	}
+}
+
+func TestCacheServesReads(t *testing.T) {
+	for _, live := range []bool{true, false} {
+		t.Run(fmt.Sprintf("live=%t", live), func(t *testing.T) {
+			server := newFakeServer(10, 10)
+			c := newCachedClient(t, server, live)
+			listWithPorts(t, c, "user3")
+			container, err := c.GetContainer(context.Background(), "user3", "c0031")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if container.Config["user.friendlyname"] != "box1" {
+				t.Fatalf("GetContainer() returned %s", container.Name)
+			}
+			if live {
+				checkRequests(t, server, 0, 0)
+			} else {
+				checkRequests(t, server, 1, 1)
+			}
+		})
+	}
+}
+
+func BenchmarkListContainers(b *testing.B) {
+	for _, live := range []bool{true, false} {
+		b.Run(fmt.Sprintf("live=%t", live), func(b *testing.B) {
+			server := newFakeServer(50, 20)
+			c := newCachedClient(b, server, live)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				listWithPorts(b, c, fmt.Sprintf("user%d", i%50))
+			}
+			b.StopTimer()
+			if live {
+				checkRequests(b, server, 0, 0)
+			} else {
+				checkRequests(b, server, 0, int64(b.N))
+			}
+			b.ReportMetric(float64(server.getInstances.Load())/float64(b.N), "lxd-requests/op")
+		})
+	}
+}
+
+func BenchmarkGetContainer(b *testing.B) {
+	for _, live := range []bool{true, false} {
+		b.Run(fmt.Sprintf("live=%t", live), func(b *testing.B) {
+			server := newFakeServer(50, 20)
+			c := newCachedClient(b, server, live)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				n := i % 1000
+				if _, err := c.GetContainer(context.Background(), fmt.Sprintf("user%d", n/20), fmt.Sprintf("c%04d", n)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			if live {
+				checkRequests(b, server, 0, 0)
+			} else {
+				checkRequests(b, server, int64(b.N), 0)
+			}
+			b.ReportMetric(float64(server.getInstance.Load())/float64(b.N), "lxd-requests/op")
+		})
+	}
+}
@@ -3,15 +3,21 @@ package main
 import (
 	"flag"
 	"fmt"
+	"lxcpanel/auth"
 	"lxcpanel/cmd"
 	"lxcpanel/common"
 	"lxcpanel/lxc"
+	"lxcpanel/metrics"
+	"lxcpanel/rest"
+	"lxcpanel/web"
+	"lxcpanel/webhook"
 	"net"
+	"os"
 	"strings"
+	"time"
 
 	_ "embed"
 
-	"github.com/anmitsu/go-shlex"
 	"github.com/charmbracelet/log"
 	"github.com/charmbracelet/ssh"
 	"github.com/charmbracelet/wish"
@@ -29,57 +35,161 @@ func main() {
 	keyPath := flag.String("key", ".ssh/id_ed25519", "path to host key")
 	defaultImage := flag.String("image", "c9fba5728bfe168a", "default image to use")
 	host := flag.String("host", "0.0.0.0", "host to listen on")
+	metricsAddr := flag.String("metrics-addr", "", "address to expose Prometheus metrics on (disabled if empty)")
+	apiAddr := flag.String("api-addr", "", "address to serve the REST API on (disabled if empty)")
+	webAddr := flag.String("web-addr", "", "address to serve the web terminal on (disabled if empty)")
+	webURL := flag.String("web-url", "", "public URL of the web terminal used in login links (defaults to -web-addr)")
+	historySize := flag.Int("history-size", 1000, "number of command history lines kept per user")
+	historyRedact := flag.Bool("history-redact", true, "redact secrets such as public keys from the saved history")
+	caAutoProvision := flag.Bool("ca-auto-provision", false, "create users logging in with a valid certificate from a trusted SSH CA")
+	requireAdminMFA := flag.Bool("require-admin-mfa", false, "only grant the permissions of elevated roles such as admin to SSH sessions that passed TOTP verification and to API tokens created in such sessions; users of these roles who never enrolled in MFA only get the user role")
+	allowCIDR := flag.String("allow-cidr", "", "comma-separated addresses and CIDR ranges allowed to connect (all if empty)")
+	denyCIDR := flag.String("deny-cidr", "", "comma-separated addresses and CIDR ranges not allowed to connect")
+	banIPFailures := flag.Int("ban-ip-failures", 5, "failed logins from an address before it is banned (0 disables)")
+	banUserFailures := flag.Int("ban-user-failures", 20, "failed logins as a username before logins without a valid key are banned (0 disables)")
+	banMFAFailures := flag.Int("ban-mfa-failures", 5, "wrong verification codes of a username before its TOTP step is banned (0 disables)")
+	banDuration := flag.Duration("ban-duration", time.Minute, "length of the first ban, doubled by every further failure")
+	banMaxDuration := flag.Duration("ban-max-duration", 24*time.Hour, "maximum length of a ban")
+	banWindow := flag.Duration("ban-window", time.Hour, "time after which failed logins are forgotten")
 	flag.Parse()
+	if flag.Arg(0) == "docs" {
+		cmd.WriteReference(os.Stdout)
+		return
+	}
 	var err error
 	common.Client, err = lxc.NewLXCClient(*profile, *defaultImage)
 	if err != nil {
 		panic(err)
 	}
 	common.InitDB(*dbPath)
+	webhook.Start(common.Client)
+	go common.Client.WatchEvents()
+	if *metricsAddr != "" {
+		metrics.RegisterPortPool(common.Client.PortUsage)
+		metrics.RegisterInstances(common.Client.CountContainersByUser)
+		go func() {
+			log.Info("Starting metrics server", "addr", *metricsAddr)
+			if err := metrics.ListenAndServe(*metricsAddr); err != nil {
+				log.Error("Metrics server stopped", "error", err)
+			}
+		}()
+	}
+	if *apiAddr != "" {
+		go func() {
+			log.Info("Starting REST API server", "addr", *apiAddr)
+			api := rest.NewServer()
+			api.RequireAdminMFA = *requireAdminMFA
+			if err := api.ListenAndServe(*apiAddr); err != nil {
+				log.Error("REST API server stopped", "error", err)
+			}
+		}()
+	}
+	if *webAddr != "" {
+		common.WebURL = *webURL
+		if common.WebURL == "" {
+			common.WebURL = "http://" + *webAddr
+		}
+		go func() {
+			log.Info("Starting web terminal server", "addr", *webAddr)
+			if err := web.NewServer().ListenAndServe(*webAddr); err != nil {
+				log.Error("Web terminal server stopped", "error", err)
+			}
+		}()
+	}
+	allow, err := auth.ParsePrefixes(*allowCIDR)
+	if err != nil {
+		panic(err)
+	}
+	deny, err := auth.ParsePrefixes(*denyCIDR)
+	if err != nil {
+		panic(err)
+	}
+	banPolicy := func(threshold int) common.BanPolicy {
+		return common.BanPolicy{Threshold: threshold, Ban: *banDuration, MaxBan: *banMaxDuration, Window: *banWindow}
+	}
+	authenticator := &auth.Authenticator{
+		CAAutoProvision: *caAutoProvision,
+		RequireAdminMFA: *requireAdminMFA,
+		IPBans:          banPolicy(*banIPFailures),
+		UserBans:        banPolicy(*banUserFailures),
+		MFABans:         banPolicy(*banMFAFailures),
+		Allow:           allow,
+		Deny:            deny,
+	}
 	s, err := wish.NewServer(
 		wish.WithAddress(net.JoinHostPort(*host, fmt.Sprintf("%d", *port))),
 		wish.WithHostKeyPath(*keyPath),
-		wish.WithPublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool {
-			user := ctx.User()
-			keys, err := common.ListPubkeys(user)
-			if err != nil {
-				return false
-			}
-			for _, dbkey := range keys {
-				upk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(dbkey.PEM))
-				if err != nil {
-					return false
-				}
-				if ssh.KeysEqual(key, upk) {
-					return true
-				}
-			}
-			return false
-		}),
+		authenticator.Option(),
 		wish.WithMiddleware(
 			func(next ssh.Handler) ssh.Handler {
 				return func(sess ssh.Session) {
+					metrics.ActiveSessions.Inc()
+					defer metrics.ActiveSessions.Dec()
 					ctx := cmd.NewCommandContext(sess)
+					ctx.SetMFAVerified(auth.MFAVerified(sess.Context()))
 
 					ip := ctx.IP()
 					prompt := "\033[01;32m" + sess.User() + "@" + ip + "\033[0m:\033[01;34mustc\033[0m$ "
 
+					if username, ok := auth.Registered(sess.Context()); ok && username != sess.User() {
+						fmt.Fprintf(sess, "Account %s created, log in again with: ssh %s@<host> -p %d\n", username, username, *port)
+						next(sess)
+						return
+					}
+
+					if err := authenticator.FinishLogin(sess.Context()); err != nil {
+						log.Error("Error finishing login", "user", sess.User(), "error", err)
+						next(sess)
+						return
+					}
 					user, err := common.GetUser(sess.User())
 					if err != nil {
 						log.Error("Error getting user", "error", err)
 						next(sess)
 						return
 					}
-					commands := cmd.BuildCmdList(user.Admin)
+					if auth.RejectSuspended(sess, user) {
+						next(sess)
+						return
+					}
+					role := authenticator.Role(sess.Context(), user)
+					ctx.SetRoleFunc(func() (common.Role, error) {
+						current, err := common.GetUser(user.Username)
+						if err != nil {
+							return common.Role{}, err
+						}
+						return authenticator.Role(sess.Context(), current), nil
+					})
+					commands := cmd.BuildCmdList(role)
+					if err := cmd.LoadAliases(commands, user.Username); err != nil {
+						log.Error("Error loading aliases", "error", err)
+					}
 					terminal := term.NewTerminal(ctx, prompt)
 					terminal.SetSize(ctx.WindowSize())
-					terminal.AutoCompleteCallback = cmd.BuildCompletionFunc(commands)
-					id := ctx.OnWindowChange(func(window ssh.Window) {
+					terminal.AutoCompleteCallback = cmd.BuildCompletionFunc(ctx, commands, terminal)
+					history, err := cmd.LoadHistory(user.Username, *historySize, *historyRedact)
+					if err != nil {
+						log.Error("Error loading history", "error", err)
+					} else {
+						terminal.History = history
+						ctx.SetHistory(history)
+					}
+					removeHandler := ctx.OnWindowChange(func(window ssh.Window) {
 						terminal.SetSize(window.Width, window.Height)
 					})
+					defer removeHandler()
 
 					fmt.Fprint(terminal, banner)
 					fmt.Fprintf(terminal, "IPv4 address: %s\n", ip)
+					if _, ok := auth.Registered(sess.Context()); ok {
+						fmt.Fprintln(terminal, "Welcome! Your account was created and this key registered to it.")
+					}
+					if role.Name != user.Role {
+						fmt.Fprintf(terminal, "The commands of the %s role require two-factor authentication, enroll with \"mfa enroll\" and log in again.\n", user.Role)
+					}
+					if err := cmd.RunStartupCommands(ctx, commands, prompt, terminal); err != nil {
+						log.Error("Error running startup commands", "error", err)
+					}
 
 					for {
 						line, err := terminal.ReadLine()
@@ -93,20 +203,22 @@ func main() {
 						if line == "" {
 							continue
 						}
-						args, _ := shlex.Split(line, true)
-						args = append([]string(nil), args...)
-						cmd := commands[args[0]]
-						if cmd != nil {
-							err := cmd.Exec(ctx, args)
+						if current, err := common.GetUser(user.Username); err == nil && current.Suspended() {
+							fmt.Fprintln(terminal, "Your account has been suspended.")
+							break
+						}
+						if strings.HasPrefix(line, "!") && history != nil {
+							line, err = history.Expand(line)
 							if err != nil {
-								fmt.Fprintf(terminal, "Error: %s\n", err)
+								fmt.Fprintf(terminal, "%s\n", err)
+								continue
 							}
-						} else {
-							fmt.Fprintf(terminal, "%s: command not found\n", args[0])
+							fmt.Fprintln(terminal, line)
+							history.Add(line)
 						}
+						cmd.RunLine(ctx, commands, line, terminal)
 					}
 
-					ctx.RemoveWindowChangeHandler(id)
 					next(sess)
 				}
 			},
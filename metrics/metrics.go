@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	ActiveSessions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lxcpanel_active_sessions",
+		Help: "Number of active SSH sessions.",
+	})
+	CommandsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lxcpanel_commands_total",
+		Help: "Number of commands executed, by command name and result.",
+	}, []string{"command", "result"})
+	LXDOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "lxcpanel_lxd_operation_duration_seconds",
+		Help:    "Latency of LXD operations issued by the panel.",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"operation", "result"})
+	AuthAttemptsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lxcpanel_auth_attempts_total",
+		Help: "Number of SSH authentication attempts, by result.",
+	}, []string{"result"})
+	AuthRejectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lxcpanel_auth_rejected_total",
+		Help: "Number of SSH connections and logins rejected before authentication, by reason.",
+	}, []string{"reason"})
+	AuthBansTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lxcpanel_auth_bans_total",
+		Help: "Number of temporary login bans, by kind.",
+	}, []string{"kind"})
+)
+
+func init() {
+	prometheus.MustRegister(ActiveSessions, CommandsTotal, LXDOperationDuration, AuthAttemptsTotal, AuthRejectedTotal, AuthBansTotal)
+}
+
+// Result converts an error into the value used for "result" labels.
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// ObserveLXD records the latency of an LXD operation started at start.
+func ObserveLXD(operation string, start time.Time, err error) {
+	LXDOperationDuration.WithLabelValues(operation, Result(err)).Observe(time.Since(start).Seconds())
+}
+
+// RegisterPortPool exposes the SSH port pool utilisation reported by f.
+func RegisterPortPool(f func() (used int, total int)) {
+	prometheus.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "lxcpanel_port_pool_used",
+			Help: "Number of SSH ports allocated from the pool.",
+		}, func() float64 {
+			used, _ := f()
+			return float64(used)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "lxcpanel_port_pool_size",
+			Help: "Total number of SSH ports in the pool.",
+		}, func() float64 {
+			_, total := f()
+			return float64(total)
+		}),
+	)
+}
+
+type instancesCollector struct {
+	desc  *prometheus.Desc
+	count func() (map[string]int, error)
+}
+
+func (c *instancesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *instancesCollector) Collect(ch chan<- prometheus.Metric) {
+	counts, err := c.count()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+	for user, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n), user)
+	}
+}
+
+// RegisterInstances exposes the number of instances per user, computed by
+// count at scrape time.
+func RegisterInstances(count func() (map[string]int, error)) {
+	prometheus.MustRegister(&instancesCollector{
+		desc: prometheus.NewDesc(
+			"lxcpanel_instances",
+			"Number of instances owned by each user.",
+			[]string{"user"}, nil,
+		),
+		count: count,
+	})
+}
+
+// ListenAndServe serves the registered metrics on addr at /metrics.
+func ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,139 @@
+package rest
+
+import (
+	"lxcpanel/common"
+	"net/http"
+)
+
+// addUserRequest takes either a role or, for older clients, the admin flag.
+type addUserRequest struct {
+	Username         string `json:"username"`
+	Admin            bool   `json:"admin"`
+	Role             string `json:"role"`
+	MaxInstanceCount *int   `json:"max_instance_count"`
+}
+
+type updateUserRequest struct {
+	Admin            *bool   `json:"admin"`
+	Role             *string `json:"role"`
+	MaxInstanceCount *int    `json:"max_instance_count"`
+}
+
+// roleOf returns the role requested through the admin flag.
+func roleOf(admin bool) string {
+	if admin {
+		return common.RoleAdmin
+	}
+	return common.RoleUser
+}
+
+type suspendUserRequest struct {
+	Reason string `json:"reason"`
+}
+
+type adminAddPubkeyRequest struct {
+	Username string `json:"username"`
+	addPubkeyRequest
+}
+
+func (s *Server) registerAdminRoutes() {
+	s.handle("GET /api/admin/users", common.PermUsersView, func(r *http.Request, user common.DBUser) (any, error) {
+		users, err := common.ListUsers()
+		if err != nil {
+			return nil, err
+		}
+		if users == nil {
+			users = []common.DBUser{}
+		}
+		return users, nil
+	})
+	s.handle("POST /api/admin/users", common.PermUsersManage, func(r *http.Request, user common.DBUser) (any, error) {
+		var req addUserRequest
+		if err := decode(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Username == "" {
+			return nil, &HTTPError{http.StatusBadRequest, "username is required"}
+		}
+		maxInstanceCount := 3
+		if req.MaxInstanceCount != nil {
+			maxInstanceCount = *req.MaxInstanceCount
+		}
+		role := req.Role
+		if role == "" {
+			role = roleOf(req.Admin)
+		}
+		if _, err := common.LookupRole(role); err != nil {
+			return nil, &HTTPError{http.StatusBadRequest, err.Error()}
+		}
+		return nil, common.AddUser(req.Username, role, maxInstanceCount)
+	})
+	s.handle("PATCH /api/admin/users/{username}", common.PermUsersManage, func(r *http.Request, user common.DBUser) (any, error) {
+		var req updateUserRequest
+		if err := decode(r, &req); err != nil {
+			return nil, err
+		}
+		username := r.PathValue("username")
+		if _, err := common.GetUser(username); err != nil {
+			return nil, err
+		}
+		if req.MaxInstanceCount != nil {
+			if err := common.ChangeMaxInstanceCount(username, *req.MaxInstanceCount); err != nil {
+				return nil, err
+			}
+		}
+		role := req.Role
+		if role == nil && req.Admin != nil {
+			legacy := roleOf(*req.Admin)
+			role = &legacy
+		}
+		if role != nil {
+			if _, err := common.LookupRole(*role); err != nil {
+				return nil, &HTTPError{http.StatusBadRequest, err.Error()}
+			}
+			if err := common.SetRole(username, *role); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	s.handle("DELETE /api/admin/users/{username}", common.PermUsersManage, func(r *http.Request, user common.DBUser) (any, error) {
+		return nil, common.DeleteUser(r.Context(), r.PathValue("username"))
+	})
+	s.handle("POST /api/admin/users/{username}/suspend", common.PermUsersManage, func(r *http.Request, user common.DBUser) (any, error) {
+		var req suspendUserRequest
+		if r.ContentLength != 0 {
+			if err := decode(r, &req); err != nil {
+				return nil, err
+			}
+		}
+		_, err := common.SuspendUser(r.Context(), r.PathValue("username"), req.Reason)
+		return nil, err
+	})
+	s.handle("POST /api/admin/users/{username}/resume", common.PermUsersManage, func(r *http.Request, user common.DBUser) (any, error) {
+		return nil, common.ResumeUser(r.PathValue("username"))
+	})
+	s.handle("GET /api/admin/pubkeys", common.PermKeysView, func(r *http.Request, user common.DBUser) (any, error) {
+		keys, err := common.ListAllPubkeys()
+		if err != nil {
+			return nil, err
+		}
+		if keys == nil {
+			keys = []common.DBPubKey{}
+		}
+		return keys, nil
+	})
+	s.handle("GET /api/admin/pubkeys/{fingerprint}", common.PermKeysView, func(r *http.Request, user common.DBUser) (any, error) {
+		return common.GetPubkey(r.PathValue("fingerprint"))
+	})
+	s.handle("POST /api/admin/pubkeys", common.PermKeysManage, func(r *http.Request, user common.DBUser) (any, error) {
+		var req adminAddPubkeyRequest
+		if err := decode(r, &req); err != nil {
+			return nil, err
+		}
+		return nil, common.AddPubkey(req.Username, req.Pubkey, req.Label, req.ExpiresAt)
+	})
+	s.handle("DELETE /api/admin/pubkeys/{username}/{fingerprint}", common.PermKeysManage, func(r *http.Request, user common.DBUser) (any, error) {
+		return nil, common.DeletePubkey(r.PathValue("username"), r.PathValue("fingerprint"))
+	})
+}
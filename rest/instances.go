@@ -0,0 +1,110 @@
+package rest
+
+import (
+	"lxcpanel/common"
+	"lxcpanel/lxc"
+	"net/http"
+
+	lxd "github.com/canonical/lxd/client"
+)
+
+type instance struct {
+	Name         string `json:"name"`
+	FriendlyName string `json:"friendly_name"`
+	Owner        string `json:"owner"`
+	Status       string `json:"status"`
+	SSHPort      int    `json:"ssh_port"`
+}
+
+type image struct {
+	Fingerprint string `json:"fingerprint"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Size        int64  `json:"size"`
+}
+
+type createInstanceRequest struct {
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint"`
+	// Team, if set, owns the instance instead of the user.
+	Team string `json:"team"`
+}
+
+func (s *Server) registerInstanceRoutes() {
+	s.handle("GET /api/instances", "", func(r *http.Request, user common.DBUser) (any, error) {
+		containers, err := common.ListAccessibleContainers(r.Context(), user.Username)
+		if err != nil {
+			return nil, err
+		}
+		instances := make([]instance, 0, len(containers))
+		for _, container := range containers {
+			instances = append(instances, instance{
+				Name:         container.Name,
+				FriendlyName: container.Config["user.friendlyname"],
+				Owner:        container.Config["user.username"],
+				Status:       container.Status,
+				SSHPort:      lxc.SSHPort(container),
+			})
+		}
+		return instances, nil
+	})
+	s.handle("GET /api/instances/{name}", "", func(r *http.Request, user common.DBUser) (any, error) {
+		return common.GetAccessibleContainer(r.Context(), user.Username, r.PathValue("name"), common.ShareView)
+	})
+	s.handle("POST /api/instances", "", func(r *http.Request, user common.DBUser) (any, error) {
+		var req createInstanceRequest
+		if err := decode(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Name == "" {
+			return nil, &HTTPError{http.StatusBadRequest, "name is required"}
+		}
+		var op lxd.Operation
+		var err error
+		if req.Team != "" {
+			op, err = common.CreateTeamContainer(r.Context(), user.Username, req.Team, req.Name, req.Fingerprint)
+		} else {
+			op, err = common.CreateContainer(r.Context(), user.Username, req.Name, req.Fingerprint)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err = lxc.Wait(r.Context(), op); err != nil {
+			return nil, err
+		}
+		return op.Get(), nil
+	})
+	s.handle("POST /api/instances/{name}/start", "", func(r *http.Request, user common.DBUser) (any, error) {
+		container, err := common.GetAccessibleContainer(r.Context(), user.Username, r.PathValue("name"), common.ShareOperate)
+		if err != nil {
+			return nil, err
+		}
+		return nil, common.Client.StartContainer(r.Context(), container.Config["user.username"], container.Name)
+	})
+	s.handle("POST /api/instances/{name}/stop", "", func(r *http.Request, user common.DBUser) (any, error) {
+		container, err := common.GetAccessibleContainer(r.Context(), user.Username, r.PathValue("name"), common.ShareOperate)
+		if err != nil {
+			return nil, err
+		}
+		return nil, common.Client.StopContainer(r.Context(), container.Config["user.username"], container.Name)
+	})
+	s.handle("DELETE /api/instances/{name}", "", func(r *http.Request, user common.DBUser) (any, error) {
+		return nil, common.DeleteContainer(r.Context(), user.Username, r.PathValue("name"))
+	})
+	s.handle("GET /api/images", "", func(r *http.Request, user common.DBUser) (any, error) {
+		images, err := common.Client.ListImages(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		result := make([]image, 0, len(images))
+		for _, img := range images {
+			result = append(result, image{
+				Fingerprint: img.Fingerprint,
+				Description: img.Properties["description"],
+				Type:        img.Type,
+				Size:        img.Size,
+			})
+		}
+		return result, nil
+	})
+}
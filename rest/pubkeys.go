@@ -0,0 +1,36 @@
+package rest
+
+import (
+	"lxcpanel/common"
+	"net/http"
+	"time"
+)
+
+type addPubkeyRequest struct {
+	Pubkey    string     `json:"pubkey"`
+	Label     string     `json:"label"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (s *Server) registerPubkeyRoutes() {
+	s.handle("GET /api/pubkeys", "", func(r *http.Request, user common.DBUser) (any, error) {
+		keys, err := common.ListPubkeys(user.Username)
+		if err != nil {
+			return nil, err
+		}
+		if keys == nil {
+			keys = []common.DBPubKey{}
+		}
+		return keys, nil
+	})
+	s.handle("POST /api/pubkeys", "", func(r *http.Request, user common.DBUser) (any, error) {
+		var req addPubkeyRequest
+		if err := decode(r, &req); err != nil {
+			return nil, err
+		}
+		return nil, common.AddPubkey(user.Username, req.Pubkey, req.Label, req.ExpiresAt)
+	})
+	s.handle("DELETE /api/pubkeys/{fingerprint}", "", func(r *http.Request, user common.DBUser) (any, error) {
+		return nil, common.DeletePubkey(user.Username, r.PathValue("fingerprint"))
+	})
+}
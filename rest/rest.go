@@ -0,0 +1,139 @@
+package rest
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"lxcpanel/common"
+	"lxcpanel/lxc"
+	"net/http"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// HTTPError is returned by handlers to control the response status code.
+type HTTPError struct {
+	Code    int
+	Message string
+}
+
+func (e *HTTPError) Error() string {
+	return e.Message
+}
+
+type handlerFunc func(r *http.Request, user common.DBUser) (any, error)
+
+type Server struct {
+	mux *http.ServeMux
+	// RequireAdminMFA withholds the permissions of elevated roles from
+	// tokens created in sessions that didn't pass MFA.
+	RequireAdminMFA bool
+}
+
+func NewServer() *Server {
+	s := &Server{
+		mux: http.NewServeMux(),
+	}
+	s.registerInstanceRoutes()
+	s.registerPubkeyRoutes()
+	s.registerAdminRoutes()
+	s.handle("GET /api/whoami", "", func(r *http.Request, user common.DBUser) (any, error) {
+		return user, nil
+	})
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s)
+}
+
+// handle registers f for pattern behind token authentication. If permission
+// is set, only users whose role grants it may call it.
+func (s *Server) handle(pattern string, permission common.Permission, f handlerFunc) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		user, mfa, err := authenticate(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if permission != "" && !s.can(user, mfa, permission) {
+			writeError(w, &HTTPError{http.StatusForbidden, "permission " + string(permission) + " required"})
+			return
+		}
+		resp, err := f(r, user)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if resp == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// can reports whether user, authenticated by a token created with or without
+// MFA, may use permission. With RequireAdminMFA, elevated roles fall back to
+// the user role for tokens created without MFA.
+func (s *Server) can(user common.DBUser, mfa bool, permission common.Permission) bool {
+	role, err := common.LookupRole(user.Role)
+	if err != nil {
+		return false
+	}
+	if role.Elevated() && s.RequireAdminMFA && !mfa {
+		role, _ = common.LookupRole(common.RoleUser)
+	}
+	return role.Has(permission)
+}
+
+func authenticate(r *http.Request) (common.DBUser, bool, error) {
+	header := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return common.DBUser{}, false, &HTTPError{http.StatusUnauthorized, "missing bearer token"}
+	}
+	user, mfa, err := common.LookupToken(token)
+	if err != nil {
+		return common.DBUser{}, false, &HTTPError{http.StatusUnauthorized, "invalid token"}
+	}
+	return user, mfa, nil
+}
+
+func decode(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return &HTTPError{http.StatusBadRequest, "invalid request body: " + err.Error()}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("Error writing response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var httpErr *HTTPError
+	code := http.StatusInternalServerError
+	switch {
+	case errors.As(err, &httpErr):
+		code = httpErr.Code
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, lxc.ErrContainerNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, common.ErrInvalidPubkey):
+		code = http.StatusBadRequest
+	case errors.Is(err, common.ErrDuplicatePubkey), errors.Is(err, common.ErrUserHasContainers):
+		code = http.StatusConflict
+	case errors.Is(err, common.ErrMaxInstanceCount):
+		code = http.StatusForbidden
+	}
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,312 @@
+package web
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"lxcpanel/common"
+	"lxcpanel/lxc"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/charmbracelet/log"
+	"github.com/gorilla/websocket"
+)
+
+//go:embed index.html
+var indexHTML []byte
+
+const (
+	sessionCookie = "lxcpanel_session"
+	sessionTTL    = 12 * time.Hour
+	// maxMessageSize bounds the frames the browser sends. A larger paste
+	// closes the terminal.
+	maxMessageSize = 64 * 1024
+)
+
+type session struct {
+	username string
+	// tokenID is the token the session was created with, 0 for login links.
+	// The session ends when the token is revoked.
+	tokenID int64
+	expires time.Time
+}
+
+// resizeMessage is sent by the browser as a text frame when the terminal is
+// resized. Keyboard input is sent as binary frames.
+type resizeMessage struct {
+	Cols int `json:"cols"`
+	Rows int `json:"rows"`
+}
+
+type Server struct {
+	mux      *http.ServeMux
+	upgrader websocket.Upgrader
+	sessions map[string]session
+	lock     sync.Mutex
+}
+
+func NewServer() *Server {
+	s := &Server{
+		mux:      http.NewServeMux(),
+		sessions: make(map[string]session),
+	}
+	s.mux.HandleFunc("GET /{$}", s.handleIndex)
+	s.mux.HandleFunc("GET /login/{code}", s.handleLoginCode)
+	s.mux.HandleFunc("POST /login", s.handleLoginToken)
+	s.mux.HandleFunc("POST /logout", s.handleLogout)
+	s.mux.HandleFunc("GET /instances", s.handleInstances)
+	s.mux.HandleFunc("GET /ws", s.handleWebSocket)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s)
+}
+
+func (s *Server) newSession(w http.ResponseWriter, username string, tokenID int64) error {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(buf)
+	s.lock.Lock()
+	now := time.Now()
+	for k, v := range s.sessions {
+		if now.After(v.expires) {
+			delete(s.sessions, k)
+		}
+	}
+	s.sessions[id] = session{
+		username: username,
+		tokenID:  tokenID,
+		expires:  now.Add(sessionTTL),
+	}
+	s.lock.Unlock()
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(sessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secureCookies(),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+// secureCookies reports whether cookies should only be sent over HTTPS,
+// which is the case when the web terminal is served through it.
+func secureCookies() bool {
+	return strings.HasPrefix(common.WebURL, "https://")
+}
+
+// user returns the user the request is authenticated as through the session
+// cookie. Suspended users and sessions of revoked tokens are rejected.
+func (s *Server) user(r *http.Request) (string, bool) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		s.lock.Lock()
+		sess, ok := s.sessions[cookie.Value]
+		s.lock.Unlock()
+		if !ok || !time.Now().Before(sess.expires) {
+			return "", false
+		}
+		if sess.tokenID != 0 {
+			exists, err := common.TokenExists(sess.tokenID)
+			if err != nil {
+				return "", false
+			}
+			if !exists {
+				s.lock.Lock()
+				delete(s.sessions, cookie.Value)
+				s.lock.Unlock()
+				return "", false
+			}
+		}
+		user, err := common.GetUser(sess.username)
+		return sess.username, err == nil && !user.Suspended()
+	}
+	return "", false
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(indexHTML)
+}
+
+func (s *Server) handleLoginCode(w http.ResponseWriter, r *http.Request) {
+	username, ok := common.RedeemLoginCode(r.PathValue("code"))
+	if !ok {
+		http.Error(w, "invalid or expired login link", http.StatusUnauthorized)
+		return
+	}
+	if err := s.newSession(w, username, 0); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (s *Server) handleLoginToken(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	user, _, err := common.LookupToken(token)
+	if err != nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	id, err := common.TokenID(token)
+	if err != nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	if err := s.newSession(w, user.Username, id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		s.lock.Lock()
+		delete(s.sessions, cookie.Value)
+		s.lock.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, Secure: secureCookies()})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (s *Server) handleInstances(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.user(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	containers, err := common.ListAccessibleContainers(r.Context(), username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	shared, err := common.ListSharedContainers(r.Context(), username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Only list the shared containers the shell can be opened in
+	for _, share := range shared {
+		if common.ShareGrants(share.Role, common.ShareShell) {
+			containers = append(containers, share.Container)
+		}
+	}
+	type instance struct {
+		Name         string `json:"name"`
+		FriendlyName string `json:"friendly_name"`
+		Status       string `json:"status"`
+	}
+	instances := make([]instance, 0, len(containers))
+	for _, container := range containers {
+		instances = append(instances, instance{
+			Name:         container.Name,
+			FriendlyName: container.Config["user.friendlyname"],
+			Status:       container.Status,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"username":  username,
+		"instances": instances,
+	})
+}
+
+// wsWriter forwards shell output to the browser as binary frames.
+type wsWriter struct {
+	conn *websocket.Conn
+	lock sync.Mutex
+}
+
+func (w *wsWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err := w.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	username, ok := s.user(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	container, err := common.GetAccessibleContainer(r.Context(), username, r.URL.Query().Get("instance"), common.ShareShell)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(maxMessageSize)
+	log.Info("Web terminal opened", "user", username, "instance", container.Name)
+
+	stdin, stdinWriter := io.Pipe()
+	ch := make(chan api.InstanceExecControl, 8)
+	go func() {
+		defer stdinWriter.Close()
+		for {
+			msgType, data, err := conn.ReadMessage()
+			if err != nil {
+				// Hang up the shell when the browser goes away
+				select {
+				case ch <- api.InstanceExecControl{Command: "signal", Signal: 1}:
+				default:
+				}
+				return
+			}
+			switch msgType {
+			case websocket.BinaryMessage:
+				if _, err := stdinWriter.Write(data); err != nil {
+					return
+				}
+			case websocket.TextMessage:
+				var msg resizeMessage
+				if json.Unmarshal(data, &msg) == nil && msg.Cols > 0 && msg.Rows > 0 {
+					select {
+					case ch <- lxc.WindowResize(msg.Cols, msg.Rows):
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	width, height := 80, 24
+	if cols, err := strconv.Atoi(r.URL.Query().Get("cols")); err == nil && cols > 0 {
+		width = cols
+	}
+	if rows, err := strconv.Atoi(r.URL.Query().Get("rows")); err == nil && rows > 0 {
+		height = rows
+	}
+	out := &wsWriter{conn: conn}
+	err = common.Client.StartShell(r.Context(), container.Name, stdin, out, width, height, ch)
+	if err != nil {
+		log.Error("Web terminal error", "user", username, "error", err)
+	}
+	stdin.Close()
+	out.lock.Lock()
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	out.lock.Unlock()
+}
@@ -0,0 +1,114 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"lxcpanel/common"
+	"lxcpanel/lxc"
+	"net/http"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/lithammer/shortuuid/v4"
+)
+
+const (
+	maxAttempts    = 5
+	initialBackoff = time.Second
+)
+
+var httpClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+// sleep waits between delivery attempts, replaced in tests.
+var sleep = time.Sleep
+
+// Start delivers every lifecycle event published by client to the matching
+// webhooks.
+func Start(client *lxc.LXCClient) {
+	client.Subscribe(func(event lxc.Event) {
+		go dispatch(event)
+	})
+}
+
+// Sign returns the value of the X-Lxcpanel-Signature header for body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func dispatch(event lxc.Event) {
+	webhooks, err := common.ListWebhooks()
+	if err != nil {
+		log.Error("Error listing webhooks", "error", err)
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Error("Error encoding webhook payload", "error", err)
+		return
+	}
+	for _, webhook := range webhooks {
+		if webhook.Matches(event.Type) {
+			go deliver(webhook, event, body)
+		}
+	}
+}
+
+// deliver posts body to the webhook, retrying with exponential backoff until
+// it answers with a 2xx status. Every attempt is recorded.
+func deliver(webhook common.DBWebhook, event lxc.Event, body []byte) {
+	id := shortuuid.New()
+	backoff := initialBackoff
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		statusCode, err := post(webhook, event, id, body)
+		delivery := common.DBWebhookDelivery{
+			WebhookID:  webhook.ID,
+			Event:      event.Type,
+			Instance:   event.Instance,
+			Attempt:    attempt,
+			StatusCode: statusCode,
+		}
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		if dbErr := common.AddWebhookDelivery(delivery); dbErr != nil {
+			log.Error("Error recording webhook delivery", "error", dbErr)
+		}
+		if err == nil {
+			return
+		}
+		log.Warn("Webhook delivery failed", "url", webhook.URL, "attempt", attempt, "error", err)
+		if attempt < maxAttempts {
+			sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+func post(webhook common.DBWebhook, event lxc.Event, id string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "lxcpanel-webhook")
+	req.Header.Set("X-Lxcpanel-Event", event.Type)
+	req.Header.Set("X-Lxcpanel-Delivery", id)
+	req.Header.Set("X-Lxcpanel-Signature", Sign(webhook.Secret, body))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
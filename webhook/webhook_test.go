@@ -0,0 +1,226 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"lxcpanel/common"
+	"lxcpanel/lxc"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// request is a delivery received by a test server.
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver records the deliveries it receives, answering them with the
+// given status codes in turn and 200 afterwards.
+type receiver struct {
+	lock     sync.Mutex
+	requests []request
+	statuses []int
+	received chan struct{}
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*receiver, *httptest.Server) {
+	r := &receiver{statuses: statuses, received: make(chan struct{}, 16)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.lock.Lock()
+		r.requests = append(r.requests, request{header: req.Header.Clone(), body: body})
+		status := http.StatusOK
+		if len(r.requests) <= len(r.statuses) {
+			status = r.statuses[len(r.requests)-1]
+		}
+		r.lock.Unlock()
+		w.WriteHeader(status)
+		r.received <- struct{}{}
+	}))
+	t.Cleanup(server.Close)
+	return r, server
+}
+
+func (r *receiver) wait(t *testing.T, n int) {
+	t.Helper()
+	for range n {
+		select {
+		case <-r.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %d deliveries", n)
+		}
+	}
+}
+
+func (r *receiver) count() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.requests)
+}
+
+func setup(t *testing.T) *[]time.Duration {
+	t.Helper()
+	common.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(func() { common.DB.Close() })
+	var lock sync.Mutex
+	var backoffs []time.Duration
+	sleep = func(d time.Duration) {
+		lock.Lock()
+		defer lock.Unlock()
+		backoffs = append(backoffs, d)
+	}
+	t.Cleanup(func() { sleep = time.Sleep })
+	return &backoffs
+}
+
+var testEvent = lxc.Event{
+	Type:         lxc.EventInstanceStarted,
+	Instance:     "c1",
+	FriendlyName: "dev",
+	Username:     "alice",
+	Source:       lxc.EventSourcePanel,
+	Timestamp:    time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+}
+
+func addWebhook(t *testing.T, url string, secret string, events ...string) common.DBWebhook {
+	t.Helper()
+	id, err := common.AddWebhook(url, secret, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return common.DBWebhook{ID: id, URL: url, Secret: secret, Events: events}
+}
+
+func TestDeliverSigned(t *testing.T) {
+	setup(t)
+	r, server := newReceiver(t)
+	webhook := addWebhook(t, server.URL, "s3cret")
+	body, err := json.Marshal(testEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliver(webhook, testEvent, body)
+
+	if r.count() != 1 {
+		t.Fatalf("got %d requests, want 1", r.count())
+	}
+	req := r.requests[0]
+	if string(req.body) != string(body) {
+		t.Errorf("body = %s, want %s", req.body, body)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(req.body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); req.header.Get("X-Lxcpanel-Signature") != want {
+		t.Errorf("signature = %q, want %q", req.header.Get("X-Lxcpanel-Signature"), want)
+	}
+	if Sign("other", req.body) == req.header.Get("X-Lxcpanel-Signature") {
+		t.Error("signature doesn't depend on the secret")
+	}
+	if got := req.header.Get("X-Lxcpanel-Event"); got != lxc.EventInstanceStarted {
+		t.Errorf("event header = %q, want %q", got, lxc.EventInstanceStarted)
+	}
+	if req.header.Get("X-Lxcpanel-Delivery") == "" {
+		t.Error("missing delivery ID")
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	backoffs := setup(t)
+	r, server := newReceiver(t, http.StatusServiceUnavailable, http.StatusBadGateway)
+	webhook := addWebhook(t, server.URL, "s3cret")
+	deliver(webhook, testEvent, []byte(`{}`))
+
+	if r.count() != 3 {
+		t.Fatalf("got %d requests, want 3", r.count())
+	}
+	if id := r.requests[0].header.Get("X-Lxcpanel-Delivery"); r.requests[2].header.Get("X-Lxcpanel-Delivery") != id {
+		t.Error("retries use a different delivery ID")
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second}; !slices.Equal(*backoffs, want) {
+		t.Errorf("backoffs = %v, want %v", *backoffs, want)
+	}
+
+	deliveries, err := common.ListWebhookDeliveries(webhook.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Reverse(deliveries)
+	want := []struct {
+		status int
+		failed bool
+	}{{503, true}, {502, true}, {200, false}}
+	if len(deliveries) != len(want) {
+		t.Fatalf("got %d recorded deliveries, want %d", len(deliveries), len(want))
+	}
+	for i, delivery := range deliveries {
+		if delivery.Attempt != i+1 || delivery.StatusCode != want[i].status || (delivery.Error != "") != want[i].failed {
+			t.Errorf("delivery %d = attempt %d, status %d, error %q", i, delivery.Attempt, delivery.StatusCode, delivery.Error)
+		}
+		if delivery.Event != testEvent.Type || delivery.Instance != testEvent.Instance {
+			t.Errorf("delivery %d recorded for %s of %s", i, delivery.Event, delivery.Instance)
+		}
+	}
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	backoffs := setup(t)
+	statuses := slices.Repeat([]int{http.StatusInternalServerError}, maxAttempts+1)
+	r, server := newReceiver(t, statuses...)
+	webhook := addWebhook(t, server.URL, "s3cret")
+	deliver(webhook, testEvent, []byte(`{}`))
+
+	if r.count() != maxAttempts {
+		t.Fatalf("got %d requests, want %d", r.count(), maxAttempts)
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}; !slices.Equal(*backoffs, want) {
+		t.Errorf("backoffs = %v, want %v", *backoffs, want)
+	}
+	deliveries, err := common.ListWebhookDeliveries(webhook.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != maxAttempts {
+		t.Fatalf("got %d recorded deliveries, want %d", len(deliveries), maxAttempts)
+	}
+	for _, delivery := range deliveries {
+		if delivery.StatusCode != http.StatusInternalServerError || delivery.Error == "" {
+			t.Errorf("attempt %d recorded with status %d and error %q", delivery.Attempt, delivery.StatusCode, delivery.Error)
+		}
+	}
+}
+
+func TestDispatchFiltersEvents(t *testing.T) {
+	setup(t)
+	all, allServer := newReceiver(t)
+	started, startedServer := newReceiver(t)
+	addWebhook(t, allServer.URL, "a")
+	addWebhook(t, startedServer.URL, "b", lxc.EventInstanceStarted)
+
+	stopped := testEvent
+	stopped.Type = lxc.EventInstanceStopped
+	dispatch(stopped)
+	dispatch(testEvent)
+	all.wait(t, 2)
+	started.wait(t, 1)
+	// Give a wrongly matched delivery the time to arrive
+	time.Sleep(50 * time.Millisecond)
+
+	if all.count() != 2 {
+		t.Errorf("webhook without filter got %d deliveries, want 2", all.count())
+	}
+	if started.count() != 1 {
+		t.Fatalf("filtered webhook got %d deliveries, want 1", started.count())
+	}
+	if got := started.requests[0].header.Get("X-Lxcpanel-Event"); got != lxc.EventInstanceStarted {
+		t.Errorf("filtered webhook got %s", got)
+	}
+}